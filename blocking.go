@@ -0,0 +1,39 @@
+package flac
+
+import "frolovo22/flac/frame"
+
+// approximate size of frame header and footer in bits
+const frameOverhead = 8 * 8
+
+// subframes of a frame which is not written yet
+type encodedFrame struct {
+	channelAssigment frame.ChannelAssigment
+	subframes        []frame.Subframe
+}
+
+// encode block of samples as frames, returns the frames and their approximate size in bits
+// with variable block size the block is split in halves recursively while the halves are smaller than the whole block,
+// the halves are not split below MinBlockSize, so transients end up in short frames and steady parts in long ones
+func (e *Encoder) encodeBlock(channels [][]int32) ([]encodedFrame, uint64) {
+	channelAssigment, subframes, size := e.encodeSubframes(channels)
+	size += frameOverhead
+	frames := []encodedFrame{{channelAssigment: channelAssigment, subframes: subframes}}
+
+	blockSize := len(channels[0])
+	half := blockSize / 2
+	if !e.options.VariableBlockSize || half < int(e.options.MinBlockSize) {
+		return frames, size
+	}
+
+	first := make([][]int32, len(channels))
+	second := make([][]int32, len(channels))
+	for i, channel := range channels {
+		first[i], second[i] = channel[:half], channel[half:]
+	}
+	firstFrames, firstSize := e.encodeBlock(first)
+	secondFrames, secondSize := e.encodeBlock(second)
+	if firstSize+secondSize < size {
+		return append(firstFrames, secondFrames...), firstSize + secondSize
+	}
+	return frames, size
+}
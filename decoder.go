@@ -0,0 +1,241 @@
+package flac
+
+import (
+	"errors"
+	"frolovo22/flac/frame"
+	"frolovo22/flac/meta"
+	"github.com/icza/bitio"
+	"io"
+	"io/ioutil"
+)
+
+// Decoder reads FLAC stream incrementally:
+// metadata blocks are read on demand, audio frames one by one,
+// so memory usage does not depend on the stream length
+type Decoder struct {
+	source       io.Reader
+	start        int64 // position of the stream marker in source if it is io.Seeker, offsets are counted from it
+	counter      *countReader
+	reader       *bitio.Reader
+	flac         FLAC
+	metadataDone bool         // last metadata block has been read
+	audioOffset  int64        // byte offset of the first audio frame, valid when metadataDone
+	pending      *frame.Frame // frame to return by the next NextFrame call, set by SeekSample
+	md5          *md5Hasher   // nil if MD5 verification is disabled
+	seeked       bool         // SeekSample was called, so the MD5 signature can not be checked
+	framesRead   bool         // NextFrame has read a frame, so the MD5 signature would miss its samples
+}
+
+// read stream marker and STREAMINFO metadata block, other metadata blocks are read on demand
+func NewDecoder(reader io.Reader) (*Decoder, error) {
+	counter := newCountReader(reader)
+	decoder := &Decoder{
+		source:  reader,
+		counter: counter,
+		reader:  bitio.NewReader(counter),
+	}
+
+	// the stream may be embedded or the reader already advanced
+	if seeker, ok := reader.(io.Seeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			decoder.start = start
+		}
+	}
+
+	// check format
+	err := decoder.flac.readMarker(decoder.reader)
+	if err != nil {
+		return decoder, err
+	}
+
+	// STREAMINFO must be the first metadata block
+	block, err := decoder.NextMetadataBlock()
+	if err != nil {
+		return decoder, err
+	}
+	if block.Header.Type != meta.StreamInfoBlockType {
+		return decoder, errors.New("first metadata block is not STREAMINFO")
+	}
+
+	return decoder, nil
+}
+
+// read next metadata block
+// returns io.EOF after the last metadata block
+func (d *Decoder) NextMetadataBlock() (*meta.MetadataBlock, error) {
+	return d.nextMetadataBlock(false)
+}
+
+// read next metadata block, PICTURE data is skipped if skipPictureData is set
+func (d *Decoder) nextMetadataBlock(skipPictureData bool) (*meta.MetadataBlock, error) {
+	if d.metadataDone {
+		return nil, io.EOF
+	}
+	var block *meta.MetadataBlock
+	var err error
+	if skipPictureData {
+		block, err = d.readMetadataBlockWithoutPictureData()
+	} else {
+		block, err = meta.ReadMetadataBlock(d.reader)
+	}
+	if err != nil {
+		return block, err
+	}
+	d.flac.MetadataBlocks = append(d.flac.MetadataBlocks, *block)
+	d.setMetadataDone(block.Header.IsLast)
+	return block, nil
+}
+
+// return metadata blocks read so far, all blocks of the stream after ReadMetadataBlocks or the first NextFrame
+func (d *Decoder) MetadataBlocks() []meta.MetadataBlock {
+	return d.flac.MetadataBlocks
+}
+
+// read all remaining metadata blocks and return all blocks of the stream
+func (d *Decoder) ReadMetadataBlocks() ([]meta.MetadataBlock, error) {
+	for {
+		_, err := d.NextMetadataBlock()
+		if err == io.EOF {
+			return d.flac.MetadataBlocks, nil
+		}
+		if err != nil {
+			return d.flac.MetadataBlocks, err
+		}
+	}
+}
+
+// read metadata block, PICTURE is read without PictureData
+func (d *Decoder) readMetadataBlockWithoutPictureData() (*meta.MetadataBlock, error) {
+	block := &meta.MetadataBlock{}
+	header, err := meta.ReadMetadataBlockHeader(d.reader)
+	if err != nil {
+		return block, err
+	}
+	block.Header = *header
+
+	if header.Type != meta.PictureBlockType {
+		block.Data, err = meta.ReadMetadataBlockData(d.reader, header)
+		return block, err
+	}
+	picture, length, err := meta.ReadPictureWithoutData(d.reader)
+	if err != nil {
+		return block, err
+	}
+	block.Data = picture
+	return block, d.skip(int64(length))
+}
+
+// skip n bytes of the stream, the source is seeked if it is io.Seeker
+func (d *Decoder) skip(n int64) error {
+	buffered := int64(d.counter.reader.Buffered())
+	seeker, ok := d.source.(io.Seeker)
+	if !ok || n <= buffered {
+		_, err := io.CopyN(ioutil.Discard, d.reader, n)
+		return err
+	}
+
+	// the source is ahead of the stream by the buffered bytes
+	_, err := seeker.Seek(n-buffered, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	offset := d.counter.offset + n
+	d.counter = newCountReader(d.source)
+	d.counter.offset = offset
+	d.reader = bitio.NewReader(d.counter)
+	return nil
+}
+
+func (d *Decoder) StreamInfo() *meta.StreamInfo {
+	return d.flac.StreamInfo()
+}
+
+func (d *Decoder) setMetadataDone(isLast bool) {
+	d.metadataDone = isLast
+	if isLast {
+		d.audioOffset = d.counter.offset
+	}
+}
+
+// read next audio frame, metadata blocks which are not read yet are read first and kept in MetadataBlocks
+// after SeekSample the first returned frame starts exactly at the target sample
+// returns io.EOF when there are no more frames and *frame.CRCError if the frame is corrupted
+func (d *Decoder) NextFrame() (*frame.Frame, error) {
+	if d.pending != nil {
+		f := d.pending
+		d.pending = nil
+		return f, nil
+	}
+
+	_, err := d.ReadMetadataBlocks()
+	if err != nil {
+		return nil, err
+	}
+
+	// frames are byte aligned, so the offset is exact
+	offset := d.counter.offset
+
+	f, err := frame.ReadFrame(d.reader, d.StreamInfo())
+	if err != io.EOF {
+		d.framesRead = true
+	}
+	if crcErr, ok := err.(*frame.CRCError); ok {
+		crcErr.Offset = offset
+	}
+	if err == nil && d.md5 != nil {
+		d.md5.writeFrame(f)
+	}
+	return f, err
+}
+
+// compute MD5 signature of the audio decoded by the next NextFrame calls
+// must be called before the first frame is read, returns ErrMD5FramesRead after NextFrame and ErrMD5Seeked after SeekSample
+func (d *Decoder) EnableMD5() error {
+	if d.seeked {
+		return ErrMD5Seeked
+	}
+	if d.framesRead {
+		return ErrMD5FramesRead
+	}
+	streamInfo := d.StreamInfo()
+	if streamInfo == nil {
+		return errors.New("STREAMINFO metadata block not found")
+	}
+	d.md5 = newMD5Hasher(streamInfo.BitsPerSample)
+	return nil
+}
+
+// compare MD5 signature of the decoded audio with the STREAMINFO MD5 signature
+// returns ErrMD5NotSet if the stream has no signature, ErrMD5Mismatch if the audio differs
+// and ErrMD5Seeked if not all audio is decoded because of SeekSample
+func (d *Decoder) CheckMD5() error {
+	if d.seeked {
+		return ErrMD5Seeked
+	}
+	if d.md5 == nil {
+		return errors.New("MD5 verification is not enabled")
+	}
+	return d.md5.check(d.StreamInfo().MD5)
+}
+
+// decode all remaining frames and check the MD5 signature, like flac -t
+// must be called before the first frame is read, unless MD5 verification is already enabled, see EnableMD5
+func (d *Decoder) Verify() error {
+	if d.md5 == nil {
+		err := d.EnableMD5()
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		_, err := d.NextFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return d.CheckMD5()
+}
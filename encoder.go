@@ -0,0 +1,429 @@
+package flac
+
+import (
+	"errors"
+	"frolovo22/flac/frame"
+	"frolovo22/flac/meta"
+	"github.com/icza/bitio"
+	"io"
+	"math"
+)
+
+// EncoderOptions describes the encoded stream and the encoding parameters
+type EncoderOptions struct {
+	SampleRate       uint32 // sample rate in Hz (1-1048575)
+	NumberOfChannels uint8  // 1-8 channels
+	BitsPerSample    uint8  // 4-32 bits per sample
+	BlockSize        uint16 // block size in inter-channel samples, 4096 if 0
+
+	VariableBlockSize bool   // split blocks into smaller frames where it makes the stream smaller, see encodeBlock
+	MinBlockSize      uint16 // smallest frame of variable block size encoding (16-BlockSize), BlockSize/8 if 0
+	Subset            bool   // restrict the stream to the streamable subset, see CheckSubset
+
+	MaxLPCOrder           int            // maximum order of LPC subframes (1-32), 0 disables LPC subframes
+	QLPPrecision          uint8          // precision of quantized LPC coefficients in bits (1-15), chosen by block size if 0
+	Windows               []frame.Window // apodization windows for LPC analysis, Tukey(0.5) if empty
+	ExhaustiveOrderSearch bool           // encode LPC subframes of every order and keep the smallest
+	MaxPartitionOrder     int            // maximum partition order of residual (0-15), like -r of the reference encoder
+	StereoMode            StereoMode     // inter-channel decorrelation of 2-channel streams
+
+	SeekPoints        int     // number of points reserved in SEEKTABLE, if 0 it is computed from the seek point interval and TotalSamples
+	SeekPointInterval uint64  // samples between seek points; if 0, points are spread evenly over TotalSamples
+	SeekPointSeconds  float64 // seconds between seek points like -S Ns of the reference encoder, alternative to SeekPointInterval
+	TotalSamples      uint64  // expected number of inter-channel samples, used only to place seek points
+
+	Concurrency int // maximum number of blocks encoded in parallel, 1 if 0; the stream is the same for any concurrency
+}
+
+func (eo *EncoderOptions) subframeOptions() *frame.SubframeOptions {
+	return &frame.SubframeOptions{
+		MaxLPCOrder:           eo.MaxLPCOrder,
+		QLPPrecision:          eo.QLPPrecision,
+		Windows:               eo.Windows,
+		ExhaustiveOrderSearch: eo.ExhaustiveOrderSearch,
+		MaxPartitionOrder:     eo.MaxPartitionOrder,
+	}
+}
+
+// block encoded in a goroutine, frames are set when done is closed
+type pendingBlock struct {
+	done   chan struct{}
+	frames []encodedFrame
+}
+
+// Encoder writes native FLAC stream from blocks of int32 samples
+type Encoder struct {
+	writer        *countWriter   // counts bytes from the stream marker
+	seeker        io.WriteSeeker // nil if the writer can not seek back to the metadata
+	start         int64          // position of the stream marker in seeker
+	options       EncoderOptions
+	streamInfo    meta.StreamInfo
+	subframe      *frame.SubframeOptions
+	samples       [][]int32 // samples per channel which are not encoded yet
+	frameNumber   uint64
+	sampleNumber  uint64 // number of the first sample of the next frame
+	md5           *md5Hasher
+	seekTable     *meta.SeekTable // nil if SEEKTABLE is not written
+	audioOffset   int64           // offset of the first frame from the stream marker
+	nextSeekPoint uint64          // sample number of the next seek point target
+
+	pending []*pendingBlock // blocks being encoded, oldest first
+	err     error           // first error of writing frames, the stream is broken after it
+
+	// block sizes of variable block size stream used so far, STREAMINFO minimum excludes the last frame
+	minimumBlockSize uint16 // minimum of the frames before the last one
+	lastBlockSize    uint16
+}
+
+// write stream marker and metadata blocks, audio is written by WriteSamples and Close
+// STREAMINFO values known only after encoding (frame sizes, total samples, MD5 signature) are written as zeros,
+// Close writes them if the writer is io.WriteSeeker, see Close
+func NewEncoder(writer io.Writer, options EncoderOptions) (*Encoder, error) {
+	// STREAMINFO sample rate is 20 bits
+	if options.SampleRate == 0 || options.SampleRate >= 1<<20 {
+		return nil, errors.New("incorrect sample rate")
+	}
+	if options.BlockSize == 0 {
+		options.BlockSize = 4096
+	}
+	if options.BlockSize < 16 {
+		return nil, errors.New("block size is less than 16")
+	}
+	if options.VariableBlockSize && options.MinBlockSize == 0 {
+		options.MinBlockSize = options.BlockSize / 8
+		if options.MinBlockSize < 16 {
+			options.MinBlockSize = 16
+		}
+	}
+	if options.VariableBlockSize && (options.MinBlockSize < 16 || options.MinBlockSize > options.BlockSize) {
+		return nil, errors.New("incorrect minimum block size")
+	}
+	if options.MaxLPCOrder < 0 || options.MaxLPCOrder > frame.MaxLPCOrder {
+		return nil, errors.New("incorrect maximum LPC order")
+	}
+	if options.QLPPrecision > frame.MaxQLPPrecision {
+		return nil, errors.New("incorrect precision of quantized LPC coefficients")
+	}
+	if options.MaxPartitionOrder < 0 || options.MaxPartitionOrder > frame.MaxPartitionOrder {
+		return nil, errors.New("incorrect maximum partition order")
+	}
+	if options.Subset {
+		err := options.checkSubset()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.StereoMode > AdaptiveStereo {
+		return nil, errors.New("incorrect stereo mode")
+	}
+	if options.Concurrency < 0 {
+		return nil, errors.New("incorrect concurrency")
+	}
+	if options.SeekPointSeconds < 0 || (options.SeekPointSeconds > 0 && options.SeekPointInterval > 0) {
+		return nil, errors.New("incorrect seek point seconds")
+	}
+	if options.SeekPointSeconds > 0 {
+		options.SeekPointInterval = uint64(math.Round(options.SeekPointSeconds * float64(options.SampleRate)))
+		if options.SeekPointInterval == 0 {
+			options.SeekPointInterval = 1
+		}
+	}
+	seekTable, err := options.seekTable()
+	if err != nil {
+		return nil, err
+	}
+
+	// frames of variable block size encoding are not split below MinBlockSize, except the last one;
+	// the bounds are replaced by the block sizes used as frames are written
+	minimumBlockSize := options.BlockSize
+	if options.VariableBlockSize {
+		minimumBlockSize = options.MinBlockSize
+	}
+
+	encoder := &Encoder{
+		writer:  &countWriter{writer: writer},
+		options: options,
+		streamInfo: meta.StreamInfo{
+			MinimumBlockSize: minimumBlockSize,
+			MaximumBlockSize: options.BlockSize,
+			SampleRate:       options.SampleRate,
+			NumberOfChannels: options.NumberOfChannels,
+			BitsPerSample:    options.BitsPerSample,
+			MD5:              make([]byte, 16),
+		},
+		subframe:  options.subframeOptions(),
+		samples:   make([][]int32, options.NumberOfChannels),
+		md5:       newMD5Hasher(options.BitsPerSample),
+		seekTable: seekTable,
+	}
+
+	// writer which implements io.Seeker may still be unable to seek, like a pipe
+	if seeker, ok := writer.(io.WriteSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			encoder.seeker = seeker
+			encoder.start = start
+		}
+	}
+
+	err = encoder.writeMetadata(encoder.writer)
+	if err != nil {
+		return nil, err
+	}
+	encoder.audioOffset = encoder.writer.offset
+
+	return encoder, nil
+}
+
+// write stream marker and metadata blocks
+func (e *Encoder) writeMetadata(writer io.Writer) error {
+	bits := bitio.NewWriter(writer)
+	_, err := bits.Write([]byte(StreamMarker))
+	if err != nil {
+		return err
+	}
+	err = meta.WriteMetadataBlock(bits, &meta.MetadataBlock{
+		Header: meta.MetadataBlockHeader{IsLast: e.seekTable == nil, Type: meta.StreamInfoBlockType},
+		Data:   &e.streamInfo,
+	})
+	if err != nil {
+		return err
+	}
+	if e.seekTable != nil {
+		err = meta.WriteMetadataBlock(bits, &meta.MetadataBlock{
+			Header: meta.MetadataBlockHeader{IsLast: true, Type: meta.SeekTableBlockType},
+			Data:   e.seekTable,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return bits.Close()
+}
+
+// return STREAMINFO of the stream
+// after Close it holds the final values, also when they could not be written to the stream
+func (e *Encoder) StreamInfo() *meta.StreamInfo {
+	return &e.streamInfo
+}
+
+// return SEEKTABLE of the stream, nil if the stream has no SEEKTABLE
+// seek points are filled as frames are written, unused points are placeholders
+func (e *Encoder) SeekTable() *meta.SeekTable {
+	return e.seekTable
+}
+
+// encode samples, one slice of samples per channel
+// samples are buffered until a whole block is collected, whole blocks are encoded in the background,
+// at most Concurrency blocks at a time, and their frames are written in order
+// after the first error of writing a frame, WriteSamples and Close return that error
+func (e *Encoder) WriteSamples(samples [][]int32) error {
+	if e.err != nil {
+		return e.err
+	}
+	if len(samples) != len(e.samples) {
+		return errors.New("number of channels does not match")
+	}
+	minimum := -int32(1 << (e.streamInfo.BitsPerSample - 1))
+	maximum := int32(1<<(e.streamInfo.BitsPerSample-1) - 1)
+	for i := range samples {
+		if len(samples[i]) != len(samples[0]) {
+			return errors.New("channels have different number of samples")
+		}
+		for _, sample := range samples[i] {
+			if sample < minimum || sample > maximum {
+				return errors.New("sample does not fit bits per sample")
+			}
+		}
+	}
+	for i := range samples {
+		e.samples[i] = append(e.samples[i], samples[i]...)
+	}
+
+	// every whole block is submitted at once, at most Concurrency blocks are encoded in parallel
+	blockSize := int(e.options.BlockSize)
+	start := 0
+	var err error
+	for len(e.samples[0])-start >= blockSize {
+		err = e.submitBlock(e.copyBlock(start, blockSize))
+		start += blockSize
+		if err != nil {
+			break
+		}
+	}
+
+	// drop submitted samples, copy to keep the buffer from growing
+	for c := range e.samples {
+		e.samples[c] = append(e.samples[c][:0], e.samples[c][start:]...)
+	}
+	return err
+}
+
+// encode the remaining samples as the last frame and complete STREAMINFO:
+// minimum and maximum frame size, total samples in stream and MD5 signature of the input samples,
+// for variable block size streams also the minimum and maximum block size used
+// if the writer is io.WriteSeeker, STREAMINFO and SEEKTABLE are rewritten at the beginning of the stream,
+// otherwise the stream keeps zeros for the unknown values and placeholder seek points,
+// the final values are only returned by StreamInfo and SeekTable
+// the underlying writer is not closed
+func (e *Encoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+
+	// whole blocks are already submitted by WriteSamples
+	if rest := len(e.samples[0]); rest > 0 {
+		err := e.submitBlock(e.copyBlock(0, rest))
+		if err != nil {
+			return err
+		}
+		for c := range e.samples {
+			e.samples[c] = e.samples[c][:0]
+		}
+	}
+	for len(e.pending) > 0 {
+		err := e.writePending()
+		if err != nil {
+			return err
+		}
+	}
+
+	e.streamInfo.TotalSamplesInStream = e.sampleNumber
+	e.streamInfo.MD5 = e.md5.hash.Sum(nil)
+
+	if e.seeker == nil {
+		return nil
+	}
+	_, err := e.seeker.Seek(e.start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = e.writeMetadata(e.seeker)
+	if err != nil {
+		return err
+	}
+	_, err = e.seeker.Seek(e.start+e.writer.offset, io.SeekStart)
+	return err
+}
+
+// number of blocks encoded in parallel
+func (e *Encoder) concurrency() int {
+	if e.options.Concurrency == 0 {
+		return 1
+	}
+	return e.options.Concurrency
+}
+
+// return copy of buffered samples of the block, the buffer is reused while the block is encoded
+func (e *Encoder) copyBlock(start int, blockSize int) [][]int32 {
+	channels := make([][]int32, len(e.samples))
+	for c := range e.samples {
+		channels[c] = append([]int32(nil), e.samples[c][start:start+blockSize]...)
+	}
+	return channels
+}
+
+// start encoding of the block in its own goroutine, then write the oldest blocks
+// while Concurrency blocks are in flight; frames are written in order, so the stream does not depend on concurrency
+func (e *Encoder) submitBlock(channels [][]int32) error {
+	block := &pendingBlock{done: make(chan struct{})}
+	go func() {
+		block.frames, _ = e.encodeBlock(channels)
+		close(block.done)
+	}()
+	e.pending = append(e.pending, block)
+
+	for len(e.pending) >= e.concurrency() {
+		err := e.writePending()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait until the oldest block in flight is encoded and write its frames
+func (e *Encoder) writePending() error {
+	block := e.pending[0]
+	e.pending[0] = nil
+	e.pending = e.pending[1:]
+	<-block.done
+
+	for i := range block.frames {
+		err := e.writeFrame(&block.frames[i])
+		if err != nil {
+			// the blocks in flight are dropped, their goroutines finish on their own
+			e.err = err
+			e.pending = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// set STREAMINFO block sizes of variable block size stream to the sizes of the frames written so far,
+// the minimum excludes the last frame unless it is the only one; STREAMINFO block sizes are at least 16
+func (e *Encoder) updateBlockSizes(blockSize uint16) {
+	if e.frameNumber == 0 {
+		e.streamInfo.MaximumBlockSize = blockSize
+	} else if e.minimumBlockSize == 0 || e.lastBlockSize < e.minimumBlockSize {
+		// the previous frame is not the last one
+		e.minimumBlockSize = e.lastBlockSize
+	}
+	if blockSize > e.streamInfo.MaximumBlockSize {
+		e.streamInfo.MaximumBlockSize = blockSize
+	}
+	e.lastBlockSize = blockSize
+
+	e.streamInfo.MinimumBlockSize = e.minimumBlockSize
+	if e.frameNumber == 0 {
+		e.streamInfo.MinimumBlockSize = blockSize
+	}
+
+	// only a single short frame is below 16, like the last frame of fixed block size stream
+	if e.streamInfo.MinimumBlockSize < 16 {
+		e.streamInfo.MinimumBlockSize = 16
+	}
+	if e.streamInfo.MaximumBlockSize < 16 {
+		e.streamInfo.MaximumBlockSize = 16
+	}
+}
+
+// write encoded frame with the frame header
+// fixed-blocksize stream headers hold the frame number, variable-blocksize stream headers hold the sample number
+func (e *Encoder) writeFrame(encoded *encodedFrame) error {
+	blockSize := len(encoded.subframes[0].Samples)
+	f := &frame.Frame{
+		Header:    frame.NewFrameHeader(uint32(blockSize), e.streamInfo.SampleRate, e.streamInfo.BitsPerSample, encoded.channelAssigment),
+		Subframes: encoded.subframes,
+	}
+	f.Header.VariableBlockSize = e.frameNumber
+	if e.options.VariableBlockSize {
+		f.Header.BlockingStrategy = frame.VariableBlockSizeStream
+		f.Header.VariableBlockSize = e.sampleNumber
+	}
+
+	offset := e.writer.offset
+	err := frame.WriteFrame(e.writer, f, &e.streamInfo)
+	if err != nil {
+		return err
+	}
+	e.md5.writeFrame(f)
+	e.addSeekPoint(uint64(offset-e.audioOffset), uint16(blockSize))
+
+	frameSize := uint32(e.writer.offset - offset)
+	if e.streamInfo.MinimumFrameSize == 0 || frameSize < e.streamInfo.MinimumFrameSize {
+		e.streamInfo.MinimumFrameSize = frameSize
+	}
+	if frameSize > e.streamInfo.MaximumFrameSize {
+		e.streamInfo.MaximumFrameSize = frameSize
+	}
+
+	if e.options.VariableBlockSize {
+		e.updateBlockSizes(uint16(blockSize))
+	}
+
+	e.frameNumber++
+	e.sampleNumber += uint64(blockSize)
+	return nil
+}
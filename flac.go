@@ -6,17 +6,56 @@ import (
 	"frolovo22/flac/meta"
 	"github.com/icza/bitio"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 )
 
 const StreamMarker = "fLaC"
 
+// ErrAudioNotAvailable is returned by Write and WriteFile if the stream was read from a reader
+// which is not io.ReaderAt and io.Seeker, so its audio frames can not be copied
+var ErrAudioNotAvailable = errors.New("audio frames of the stream are not available")
+
 type FLAC struct {
 	Marker         string // always "fLaC"
 	MetadataBlocks []meta.MetadataBlock
 	Frame          frame.Frame
+	audio          *audioSource // audio frames copied by Write, nil if unknown
+	audioOffset    int64        // offset of the first frame from the stream marker
+}
+
+// audioSource holds the audio frames of the read stream: a file or a reader
+// the audio frames are copied from it by Write, so the file or the reader must not change in between
+type audioSource struct {
+	path   string      // file read by ReadFile or ReadMetadataFile
+	reader io.ReaderAt // reader of Read which is io.ReaderAt and io.Seeker
+	offset int64       // offset of the first frame in path or reader
 }
 
+// copy the audio frames to writer
+func (as *audioSource) copyTo(writer io.Writer) error {
+	if as.path == "" {
+		_, err := io.Copy(writer, io.NewSectionReader(as.reader, as.offset, math.MaxInt64-as.offset))
+		return err
+	}
+
+	file, err := os.Open(as.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Seek(as.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// read the file, the audio frames are copied from the file by Write
 func ReadFile(path string) (*FLAC, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -24,38 +63,137 @@ func ReadFile(path string) (*FLAC, error) {
 	}
 	defer file.Close()
 
-	return Read(file)
+	f, err := Read(file)
+	if f.audio != nil {
+		f.audio = &audioSource{path: path, offset: f.audio.offset}
+	}
+	return f, err
 }
 
-func Read(reader io.Reader) (*FLAC, error) {
-	flac := FLAC{}
+// decode the whole file and check the MD5 signature, like flac -t
+func VerifyFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	bits := bitio.NewReader(reader)
+	decoder, err := NewDecoder(file)
+	if err != nil {
+		return err
+	}
+	return decoder.Verify()
+}
 
-	// check format
-	err := flac.readMarker(bits)
+// read metadata blocks and the first frame
+// the audio frames are copied by Write only if reader is io.ReaderAt and io.Seeker, they are not buffered in memory
+func Read(reader io.Reader) (*FLAC, error) {
+	readerAt, start := readerAtStart(reader)
+	decoder, err := NewDecoder(reader)
 	if err != nil {
-		return &flac, err
+		return &decoder.flac, err
 	}
 
 	// read metadata
-	err = flac.readMetadata(bits)
+	_, err = decoder.ReadMetadataBlocks()
+	if err != nil {
+		return &decoder.flac, err
+	}
+	decoder.flac.audioOffset = decoder.audioOffset
+
+	if readerAt != nil {
+		decoder.flac.audio = &audioSource{reader: readerAt, offset: start + decoder.audioOffset}
+	}
+
+	// read first frame
+	frame, err := decoder.NextFrame()
+	if frame != nil {
+		decoder.flac.Frame = *frame
+	}
+	return &decoder.flac, err
+}
+
+// return reader as io.ReaderAt and the position of the stream in it,
+// nil if reader is not io.ReaderAt and io.Seeker
+func readerAtStart(reader io.Reader) (io.ReaderAt, int64) {
+	readerAt, ok := reader.(io.ReaderAt)
+	seeker, isSeeker := reader.(io.Seeker)
+	if !ok || !isSeeker {
+		return nil, 0
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0
+	}
+	return readerAt, start
+}
+
+// MetadataOptions are options of ReadMetadataWithOptions
+type MetadataOptions struct {
+	// PICTURE blocks are read without PictureData and with DataSkipped set, the data is skipped by seeking where possible;
+	// the metadata can not be written until such blocks are removed
+	SkipPictureData bool
+}
+
+// read stream marker and metadata blocks, audio frames are not read, see ReadMetadataWithOptions
+func ReadMetadata(reader io.Reader) (*FLAC, error) {
+	return ReadMetadataWithOptions(reader, MetadataOptions{})
+}
+
+// read stream marker and metadata blocks up to the last metadata block, audio frames are not read
+// the audio frames are copied by Write only if reader is io.ReaderAt and io.Seeker
+func ReadMetadataWithOptions(reader io.Reader, options MetadataOptions) (*FLAC, error) {
+	readerAt, start := readerAtStart(reader)
+	decoder, err := NewDecoder(reader)
 	if err != nil {
-		return &flac, err
+		return &decoder.flac, err
 	}
 
-	// read frames
-	err = flac.readFrame(bits)
+	for {
+		_, err = decoder.nextMetadataBlock(options.SkipPictureData)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &decoder.flac, err
+		}
+	}
+
+	decoder.flac.audioOffset = decoder.audioOffset
+	if readerAt != nil {
+		decoder.flac.audio = &audioSource{reader: readerAt, offset: start + decoder.audioOffset}
+	}
+	return &decoder.flac, nil
+}
+
+// read stream marker and metadata blocks of the file, see ReadMetadataFileWithOptions
+func ReadMetadataFile(path string) (*FLAC, error) {
+	return ReadMetadataFileWithOptions(path, MetadataOptions{})
+}
+
+// read stream marker and metadata blocks of the file, audio frames are not read
+func ReadMetadataFileWithOptions(path string, options MetadataOptions) (*FLAC, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return &flac, err
+		return nil, err
+	}
+	defer file.Close()
+
+	f, err := ReadMetadataWithOptions(file, options)
+	if f.audio != nil {
+		f.audio = &audioSource{path: path, offset: f.audio.offset}
 	}
+	return f, err
+}
 
-	return &flac, nil
+// return byte offset of the first audio frame from the beginning of the stream
+func (f *FLAC) AudioOffset() int64 {
+	return f.audioOffset
 }
 
 func (f *FLAC) readMarker(reader *bitio.Reader) error {
 	marker := make([]byte, 4)
-	_, err := reader.Read(marker)
+	_, err := io.ReadFull(reader, marker)
 	if err != nil {
 		return err
 	}
@@ -66,24 +204,116 @@ func (f *FLAC) readMarker(reader *bitio.Reader) error {
 	return nil
 }
 
-func (f *FLAC) readMetadata(reader *bitio.Reader) error {
-	isLast := false
-	for !isLast {
-		metadata, err := meta.ReadMetadataBlock(reader)
+// write stream marker, metadata blocks and the audio frames of the read stream without decoding them
+// the metadata blocks are written as they are, only STREAMINFO must be the first one, the metadata block methods keep the other rules;
+// metadata block lengths are computed from the data and only the last block is marked as the last one
+func (f *FLAC) Write(writer io.Writer) error {
+	if f.audio == nil {
+		return ErrAudioNotAvailable
+	}
+	err := f.writeMetadata(writer)
+	if err != nil {
+		return err
+	}
+	return f.audio.copyTo(writer)
+}
+
+// write stream marker and metadata blocks
+func (f *FLAC) writeMetadata(writer io.Writer) error {
+	err := checkFirstMetadataBlock(f.MetadataBlocks)
+	if err != nil {
+		return err
+	}
+	for _, block := range f.MetadataBlocks {
+		if picture, ok := block.Data.(*meta.Picture); ok && picture.DataSkipped {
+			return errors.New("PICTURE data is not read")
+		}
+	}
+	_, err = writer.Write([]byte(StreamMarker))
+	if err != nil {
+		return err
+	}
+	for i := range f.MetadataBlocks {
+		block := f.MetadataBlocks[i]
+		block.Header.IsLast = i == len(f.MetadataBlocks)-1
+		_, err = block.WriteTo(writer)
 		if err != nil {
 			return err
 		}
-		f.MetadataBlocks = append(f.MetadataBlocks, *metadata)
-		isLast = metadata.Header.IsLast
 	}
 	return nil
 }
 
-func (f *FLAC) readFrame(reader *bitio.Reader) error {
-	frame, err := frame.ReadFrame(reader)
+// write the stream to a temporary file next to path and rename it to path,
+// so path may be the file the stream was read from; the file mode of existing path is kept
+func (f *FLAC) WriteFile(path string) error {
+	if f.audio == nil {
+		return ErrAudioNotAvailable
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	f.Frame = *frame
+	defer os.Remove(file.Name())
+
+	audioOffset, err := f.writeTemporary(file, path)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// the audio frames are in the new file now
+	f.audio = &audioSource{path: path, offset: audioOffset}
+	f.audioOffset = audioOffset
+	return nil
+}
+
+// write the stream to temporary file which replaces path, returns offset of the audio frames
+func (f *FLAC) writeTemporary(file *os.File, path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err == nil {
+		err = file.Chmod(info.Mode())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	counter := &countWriter{writer: file}
+	err = f.writeMetadata(counter)
+	if err != nil {
+		return 0, err
+	}
+	err = f.audio.copyTo(file)
+	if err != nil {
+		return 0, err
+	}
+	return counter.offset, file.Sync()
+}
+
+// return STREAMINFO metadata block, nil if not found
+func (f *FLAC) StreamInfo() *meta.StreamInfo {
+	for _, block := range f.MetadataBlocks {
+		if streamInfo, ok := block.Data.(*meta.StreamInfo); ok {
+			return streamInfo
+		}
+	}
+	return nil
+}
+
+// return SEEKTABLE metadata block, nil if not found
+func (f *FLAC) SeekTable() *meta.SeekTable {
+	for _, block := range f.MetadataBlocks {
+		if seekTable, ok := block.Data.(*meta.SeekTable); ok {
+			return seekTable
+		}
+	}
 	return nil
 }
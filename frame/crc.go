@@ -0,0 +1,80 @@
+package frame
+
+import "fmt"
+
+// CRC-8, polynomial = x^8 + x^2 + x^1 + x^0, initialized with 0
+var crc8Table = makeCRC8Table(0x07)
+
+// CRC-16, polynomial = x^16 + x^15 + x^2 + x^0, initialized with 0
+var crc16Table = makeCRC16Table(0x8005)
+
+func makeCRC8Table(polynomial uint8) (table [256]uint8) {
+	for i := range table {
+		crc := uint8(i)
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ polynomial
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func makeCRC16Table(polynomial uint16) (table [256]uint16) {
+	for i := range table {
+		crc := uint16(i) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ polynomial
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func updateCRC8(crc uint8, b byte) uint8 {
+	return crc8Table[crc^b]
+}
+
+func updateCRC16(crc uint16, b byte) uint16 {
+	return crc<<8 ^ crc16Table[byte(crc>>8)^b]
+}
+
+func crc8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc = updateCRC8(crc, b)
+	}
+	return crc
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = updateCRC16(crc, b)
+	}
+	return crc
+}
+
+// CRCError is returned when the frame header CRC-8 or the frame footer CRC-16 does not match the read data
+type CRCError struct {
+	FrameNumber uint64 // frame number, or sample number of the first sample for variable-blocksize stream
+	Offset      int64  // byte offset of the frame in the stream, -1 if unknown
+	Footer      bool   // false for frame header CRC-8, true for frame footer CRC-16
+	Stored      uint16 // CRC read from the stream
+	Computed    uint16 // CRC computed from the read data
+}
+
+func (e *CRCError) Error() string {
+	name := "CRC-8"
+	if e.Footer {
+		name = "CRC-16"
+	}
+	return fmt.Sprintf("frame %d at offset %d: %s mismatch: stored 0x%X, computed 0x%X", e.FrameNumber, e.Offset, name, e.Stored, e.Computed)
+}
@@ -0,0 +1,206 @@
+package frame
+
+import (
+	"math"
+	"math/bits"
+)
+
+// SubframeOptions holds parameters of subframe encoding
+type SubframeOptions struct {
+	// maximum order of LPC subframes, 0 disables LPC subframes
+	MaxLPCOrder int
+
+	// precision of quantized LPC coefficients in bits, chosen by block size and bits per sample if 0
+	QLPPrecision uint8
+
+	// apodization windows tried for LPC analysis, Tukey(0.5) if empty
+	Windows []Window
+
+	// maximum partition order of residual (0-15), limited by the block size and predictor order
+	MaxPartitionOrder int
+
+	// encode LPC subframes of every order and keep the smallest,
+	// instead of encoding only the order with the smallest estimated size
+	ExhaustiveOrderSearch bool
+}
+
+// choose subframe type and parameters with the smallest size for samples of one channel
+// samples - samples of the coded channel (side channel for stereo decorrelation)
+// bitsPerSample - sample size of the channel (including the extra bit of side channel)
+// returns the subframe and its size in bits
+func EncodeSubframe(samples []int32, bitsPerSample uint8, options *SubframeOptions) (Subframe, uint64) {
+	blockSize := len(samples)
+
+	// CONSTANT
+	constant := true
+	var or int32
+	for _, sample := range samples {
+		if sample != samples[0] {
+			constant = false
+		}
+		or |= sample
+	}
+	if constant {
+		subframe := Subframe{
+			Header:  SubframeHeader{Type: ConstantSubframe},
+			Samples: samples,
+		}
+		return subframe, 8 + uint64(bitsPerSample)
+	}
+
+	// wasted bits, common zero low bits of all samples
+	wastedBits := uint8(bits.TrailingZeros32(uint32(or)))
+	if wastedBits >= bitsPerSample {
+		wastedBits = bitsPerSample - 1
+	}
+	shifted := samples
+	if wastedBits > 0 {
+		shifted = make([]int32, blockSize)
+		for i, sample := range samples {
+			shifted[i] = sample >> wastedBits
+		}
+	}
+	bitsPerSample -= wastedBits
+	headerSize := 8 + uint64(wastedBits)
+
+	// VERBATIM
+	best := Subframe{
+		Header: SubframeHeader{Type: VerbatimSubframe},
+	}
+	bestSize := headerSize + uint64(blockSize)*uint64(bitsPerSample)
+
+	// FIXED
+	for order := 0; order <= 4 && order < blockSize; order++ {
+		residual, ok := fixedResidual(shifted, order)
+		if !ok {
+			continue
+		}
+		coding, residualSize := encodeResidual(residual, blockSize, order, options.MaxPartitionOrder)
+		size := headerSize + uint64(order)*uint64(bitsPerSample) + residualSize
+		if size < bestSize {
+			best = Subframe{
+				Header:   SubframeHeader{Type: FixedSubframe + SubframeType(order)},
+				Warmup:   shifted[:order],
+				Residual: coding,
+			}
+			bestSize = size
+		}
+	}
+
+	// LPC
+	maxOrder := options.MaxLPCOrder
+	if maxOrder > MaxLPCOrder {
+		maxOrder = MaxLPCOrder
+	}
+	if maxOrder > blockSize-1 {
+		maxOrder = blockSize - 1
+	}
+	if maxOrder > 0 {
+		windows := options.Windows
+		if len(windows) == 0 {
+			windows = []Window{TukeyWindow(0.5)}
+		}
+		for _, window := range windows {
+			subframe, size, ok := encodeLPC(shifted, bitsPerSample, maxOrder, window(blockSize), options)
+			if ok && headerSize+size < bestSize {
+				best = subframe
+				bestSize = headerSize + size
+			}
+		}
+	}
+
+	best.Header.WastedBits = wastedBits
+	best.Samples = samples
+	return best, bestSize
+}
+
+// encode LPC subframe with coefficients computed on the windowed samples
+// returns the subframe and its size in bits without subframe header
+func encodeLPC(samples []int32, bitsPerSample uint8, maxOrder int, window []float64, options *SubframeOptions) (Subframe, uint64, bool) {
+	blockSize := len(samples)
+	autoc := autocorrelation(samples, window, maxOrder)
+	coefficients, predictionErrors := levinsonDurbin(autoc, maxOrder)
+	if len(coefficients) == 0 {
+		return Subframe{}, 0, false
+	}
+
+	precision := func(order int) uint8 {
+		if options.QLPPrecision > 0 {
+			return options.QLPPrecision
+		}
+		return defaultQLPPrecision(bitsPerSample, blockSize, order)
+	}
+
+	// orders to encode
+	var orders []int
+	if options.ExhaustiveOrderSearch {
+		for order := 1; order <= len(coefficients); order++ {
+			orders = append(orders, order)
+		}
+	} else {
+		bestOrder, bestBits := 1, math.Inf(1)
+		for order := 1; order <= len(coefficients); order++ {
+			bits := expectedBitsPerResidual(predictionErrors[order-1], blockSize)*float64(blockSize-order) +
+				float64(order*(int(bitsPerSample)+int(precision(order))))
+			if bits < bestBits {
+				bestOrder, bestBits = order, bits
+			}
+		}
+		orders = []int{bestOrder}
+	}
+
+	var best Subframe
+	var bestSize uint64
+	found := false
+	for _, order := range orders {
+		qlpPrecision := precision(order)
+		quantized, shift, ok := quantizeCoefficients(coefficients[order-1], qlpPrecision)
+		if !ok {
+			continue
+		}
+		residual, ok := lpcResidual(samples, quantized, shift)
+		if !ok {
+			continue
+		}
+		coding, residualSize := encodeResidual(residual, blockSize, order, options.MaxPartitionOrder)
+
+		// warm-up samples, precision, shift, coefficients and residual
+		size := uint64(order)*uint64(bitsPerSample) + 4 + 5 + uint64(order)*uint64(qlpPrecision) + residualSize
+		if !found || size < bestSize {
+			best = Subframe{
+				Header:          SubframeHeader{Type: LPCSubframe + SubframeType(order-1)},
+				Warmup:          samples[:order],
+				QLPPrecision:    qlpPrecision,
+				QLPShift:        shift,
+				QLPCoefficients: quantized,
+				Residual:        coding,
+			}
+			bestSize = size
+			found = true
+		}
+	}
+	return best, bestSize, found
+}
+
+// return estimated size in bits of subframe for samples of one channel, much faster than EncodeSubframe
+// the smallest residual of FIXED predictors is used for the estimate
+func EstimateSubframeSize(samples []int32, bitsPerSample uint8) uint64 {
+	blockSize := len(samples)
+	best := uint64(blockSize) * uint64(bitsPerSample)
+	for order := 0; order <= 4 && order < blockSize; order++ {
+		residual, ok := fixedResidual(samples, order)
+		if !ok {
+			continue
+		}
+		stats := partitionStats{count: len(residual)}
+		for _, value := range residual {
+			stats.sum += uint64(fold(value))
+		}
+		size, _ := stats.riceSize(maxRice2Parameter)
+		size += uint64(order) * uint64(bitsPerSample)
+		if size < best {
+			best = size
+		}
+	}
+	return best
+}
@@ -1,12 +1,34 @@
 package frame
 
-import "github.com/icza/bitio"
+import (
+	"bytes"
+	"errors"
+	"frolovo22/flac/meta"
+	"github.com/icza/bitio"
+	"io"
+)
 
 type Frame struct {
-	Header FrameHeader
+	Header    FrameHeader
+	Subframes []Subframe // one subframe per channel, stereo decorrelation is already undone
+
+	// CRC-16 (polynomial = x^16 + x^15 + x^2 + x^0, initialized with 0) of everything before the crc, back to and including the frame header sync code
+	CRC16 uint16
+}
+
+// read frame with the frame footer
+// returns io.EOF if the stream ends before the frame and io.ErrUnexpectedEOF if it ends inside the frame
+// returns *CRCError if the frame header or the frame is corrupted
+func ReadFrame(reader *bitio.Reader, streamInfo *meta.StreamInfo) (*Frame, error) {
+	frameReader := newFrameReader(reader)
+	frame, err := readFrame(frameReader, streamInfo)
+	if err == io.EOF && frameReader.bits > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return frame, err
 }
 
-func ReadFrame(reader *bitio.Reader) (*Frame, error) {
+func readFrame(reader *frameReader, streamInfo *meta.StreamInfo) (*Frame, error) {
 	frame := &Frame{}
 
 	// header
@@ -16,5 +38,188 @@ func ReadFrame(reader *bitio.Reader) (*Frame, error) {
 	}
 	frame.Header = *header
 
+	// block size in inter-channel samples
+	blockSize := int(header.GetBlockSize())
+	if blockSize == 0 {
+		return frame, errors.New("reserved block size")
+	}
+	if streamInfo != nil && streamInfo.MaximumBlockSize >= 16 && blockSize > int(streamInfo.MaximumBlockSize) {
+		return frame, errors.New("block size is greater than STREAMINFO maximum block size")
+	}
+
+	// sample size in bits
+	bitsPerSample := header.GetBitsPerSample(streamInfo)
+	if bitsPerSample == 0 {
+		return frame, errors.New("unknown sample size")
+	}
+
+	// subframes
+	numberOfChannels := header.ChannelAssigment.NumberOfChannels()
+	if numberOfChannels == 0 {
+		return frame, errors.New("reserved channel assigment")
+	}
+	var side []int64 // side channel samples, they do not fit int32 in 32 bit stereo
+	for i := 0; i < numberOfChannels; i++ {
+		// side channel has one extra bit of sample size
+		channelBitsPerSample := bitsPerSample
+		if i == header.ChannelAssigment.SideChannel() {
+			channelBitsPerSample++
+		}
+
+		subframe, samples, err := readSubframe(reader, blockSize, channelBitsPerSample)
+		if err != nil {
+			return frame, err
+		}
+		frame.Subframes = append(frame.Subframes, *subframe)
+		if i == header.ChannelAssigment.SideChannel() {
+			side = samples
+		}
+	}
+
+	// inter-channel decorrelation
+	frame.decorrelate(side)
+
+	// zero-padding to byte alignment
+	err = reader.align()
+	if err != nil {
+		return frame, err
+	}
+
+	// footer
+	computed := reader.crc16
+	crc, err := reader.ReadBits(16)
+	if err != nil {
+		return frame, err
+	}
+	frame.CRC16 = uint16(crc)
+	if frame.CRC16 != computed {
+		return frame, &CRCError{
+			FrameNumber: header.VariableBlockSize,
+			Offset:      -1,
+			Footer:      true,
+			Stored:      frame.CRC16,
+			Computed:    computed,
+		}
+	}
+
 	return frame, nil
 }
+
+// restore left and right channels of left/side, right/side and mid/side stereo in place
+// side holds the whole side channel samples, Samples of the side subframe are truncated to 32 bits
+func (f *Frame) decorrelate(side []int64) {
+	if len(f.Subframes) != 2 || f.Header.ChannelAssigment.SideChannel() < 0 {
+		return
+	}
+	first := f.Subframes[0].Samples
+	second := f.Subframes[1].Samples
+
+	switch f.Header.ChannelAssigment {
+	case LeftSideStereo:
+		// left, side
+		for i := range second {
+			second[i] = int32(int64(first[i]) - side[i])
+		}
+	case RightSideStereo:
+		// side, right
+		for i := range first {
+			first[i] = int32(side[i] + int64(second[i]))
+		}
+	case MidSideStereo:
+		// mid, side
+		for i := range second {
+			// the lowest bit of mid was lost on encoding, it equals the lowest bit of side
+			mid := int64(first[i])<<1 | side[i]&1
+			first[i] = int32((mid + side[i]) >> 1)
+			second[i] = int32((mid - side[i]) >> 1)
+		}
+	}
+}
+
+// write frame with the frame footer, CRC-8 and CRC-16 are computed and stored in the frame
+// subframes hold samples of the channels, stereo decorrelation is done by the channel assigment
+func WriteFrame(writer io.Writer, f *Frame, streamInfo *meta.StreamInfo) error {
+	header := &f.Header
+	blockSize := int(header.GetBlockSize())
+	bitsPerSample := header.GetBitsPerSample(streamInfo)
+	if bitsPerSample == 0 {
+		return errors.New("unknown sample size")
+	}
+	if len(f.Subframes) != header.ChannelAssigment.NumberOfChannels() {
+		return errors.New("number of subframes does not match channel assigment")
+	}
+	channels := make([][]int32, len(f.Subframes))
+	for i := range f.Subframes {
+		channels[i] = f.Subframes[i].Samples
+		if len(channels[i]) != blockSize {
+			return errors.New("number of samples does not match block size")
+		}
+	}
+
+	var buffer bytes.Buffer
+	bits := bitio.NewWriter(&buffer)
+
+	// header
+	err := writeFrameHeader(bits, header)
+	if err != nil {
+		return err
+	}
+	header.CRC8 = crc8(buffer.Bytes())
+	bits.TryWriteBits(uint64(header.CRC8), 8)
+
+	// subframes
+	channels = CodedChannels(header.ChannelAssigment, channels)
+	for i := range f.Subframes {
+		channelBitsPerSample := bitsPerSample
+		if i == header.ChannelAssigment.SideChannel() {
+			channelBitsPerSample++
+		}
+		err = writeSubframe(bits, &f.Subframes[i], channels[i], channelBitsPerSample)
+		if err != nil {
+			return err
+		}
+	}
+
+	// zero-padding to byte alignment
+	_, err = bits.Align()
+	if err != nil {
+		return err
+	}
+
+	// footer
+	f.CRC16 = crc16(buffer.Bytes())
+	bits.TryWriteBits(uint64(f.CRC16), 16)
+	if bits.TryError != nil {
+		return bits.TryError
+	}
+
+	_, err = writer.Write(buffer.Bytes())
+	return err
+}
+
+// return coded channels for channel assigment, inverse of decorrelate
+// channels - samples of the channels, for stereo: left and right
+func CodedChannels(channelAssigment ChannelAssigment, channels [][]int32) [][]int32 {
+	if len(channels) != 2 || channelAssigment.SideChannel() < 0 {
+		return channels
+	}
+	left, right := channels[0], channels[1]
+	side := make([]int32, len(left))
+	for i := range side {
+		side[i] = left[i] - right[i]
+	}
+
+	switch channelAssigment {
+	case LeftSideStereo:
+		return [][]int32{left, side}
+	case RightSideStereo:
+		return [][]int32{side, right}
+	}
+
+	// mid/side
+	mid := make([]int32, len(left))
+	for i := range mid {
+		mid[i] = int32((int64(left[i]) + int64(right[i])) >> 1)
+	}
+	return [][]int32{mid, side}
+}
@@ -1,6 +1,8 @@
 package frame
 
 import (
+	"errors"
+	"frolovo22/flac/meta"
 	"github.com/icza/bitio"
 )
 
@@ -83,6 +85,7 @@ type FrameHeader struct {
 	//   <8-56>:"UTF-8" coded sample number (decoded number is 36 bits)
 	// else
 	//   <8-48>:"UTF-8" coded frame number (decoded number is 31 bits)
+	// holds the decoded number
 	VariableBlockSize uint64
 
 	// if(blocksize bits == 011x)
@@ -104,12 +107,19 @@ type SampleRate uint8
 type ChannelAssigment uint8
 type SampleSize uint8
 
+// frame sync code 11 1111 1111 1110
+const SyncCode = 0x3FFE
+
 const (
 	MandatoryValue       Reserved = false
 	ReservedForFutureUse Reserved = true
 
 	FixedBlockSizeStream    BlockingStrategy = false
 	VariableBlockSizeStream BlockingStrategy = true
+
+	LeftSideStereo  ChannelAssigment = 8
+	RightSideStereo ChannelAssigment = 9
+	MidSideStereo   ChannelAssigment = 10
 )
 
 // return block size
@@ -151,38 +161,131 @@ func (bs BlockSize) BlockSize() uint32 {
 	return 0
 }
 
-// return sample rate in kHz
-func (sr SampleRate) SampleRate() float32 {
+// return sample rate in Hz
+func (sr SampleRate) SampleRate() uint32 {
 	switch sr {
 	case 0:
 		return 0
 	case 1:
-		return 88.2
+		return 88200
 	case 2:
-		return 176.4
+		return 176400
 	case 3:
-		return 192
+		return 192000
 	case 4:
-		return 8
+		return 8000
 	case 5:
-		return 16
+		return 16000
 	case 6:
-		return 22.05
+		return 22050
 	case 7:
-		return 24
+		return 24000
 	case 8:
-		return 32
+		return 32000
 	case 9:
-		return 44.1
+		return 44100
 	case 10:
-		return 48
+		return 48000
 	case 11:
-		return 96
+		return 96000
+	}
+	return 0
+}
+
+// return block size in inter-channel samples, including the 8/16 bit values from end of header
+func (fh *FrameHeader) GetBlockSize() uint32 {
+	switch fh.BlockSize {
+	case 6, 7:
+		return uint32(fh.BlockSizeEnd) + 1
+	}
+	return fh.BlockSize.BlockSize()
+}
+
+// return number of the first sample in the frame
+// for fixed-blocksize stream it is computed from the frame number and the STREAMINFO block size
+func (fh *FrameHeader) GetSampleNumber(streamInfo *meta.StreamInfo) uint64 {
+	if fh.BlockingStrategy == VariableBlockSizeStream {
+		return fh.VariableBlockSize
+	}
+	blockSize := uint64(fh.GetBlockSize())
+	if streamInfo != nil && streamInfo.MaximumBlockSize > 0 {
+		blockSize = uint64(streamInfo.MaximumBlockSize)
+	}
+	return fh.VariableBlockSize * blockSize
+}
+
+// return sample rate in Hz, including the values from end of header
+// streamInfo is used for "get from STREAMINFO metadata block" code and may be nil
+func (fh *FrameHeader) GetSampleRate(streamInfo *meta.StreamInfo) uint32 {
+	switch fh.SampleRate {
+	case 0:
+		if streamInfo != nil {
+			return streamInfo.SampleRate
+		}
+	case 12:
+		return uint32(fh.SampleRateEnd) * 1000
+	case 13:
+		return uint32(fh.SampleRateEnd)
+	case 14:
+		return uint32(fh.SampleRateEnd) * 10
+	}
+	return fh.SampleRate.SampleRate()
+}
+
+// return sample size in bits
+// streamInfo is used for "get from STREAMINFO metadata block" code and may be nil
+func (fh *FrameHeader) GetBitsPerSample(streamInfo *meta.StreamInfo) uint8 {
+	if fh.SampleSize == 0 && streamInfo != nil {
+		return streamInfo.BitsPerSample
+	}
+	return fh.SampleSize.SampleSize()
+}
+
+// return number of channels
+func (ca ChannelAssigment) NumberOfChannels() int {
+	switch {
+	case ca < 8:
+		return int(ca) + 1
+	case ca <= 10:
+		return 2
 	}
 	return 0
 }
 
-func readFrameHeader(reader *bitio.Reader) (*FrameHeader, error) {
+// return index of the side channel, -1 if channels are independent
+func (ca ChannelAssigment) SideChannel() int {
+	switch ca {
+	case LeftSideStereo, MidSideStereo:
+		return 1
+	case RightSideStereo:
+		return 0
+	}
+	return -1
+}
+
+// return sample size in bits
+func (ss SampleSize) SampleSize() uint8 {
+	switch ss {
+	case 1:
+		return 8
+	case 2:
+		return 12
+	case 4:
+		return 16
+	case 5:
+		return 20
+	case 6:
+		return 24
+	}
+	return 0
+}
+
+// read frame header only, the CRC-8 is verified
+func ReadFrameHeader(reader *bitio.Reader) (*FrameHeader, error) {
+	return readFrameHeader(newFrameReader(reader))
+}
+
+func readFrameHeader(reader *frameReader) (*FrameHeader, error) {
 	header := &FrameHeader{}
 
 	// always 11 1111 1111 1110
@@ -191,6 +294,9 @@ func readFrameHeader(reader *bitio.Reader) (*FrameHeader, error) {
 		return header, err
 	}
 	header.SyncCode = uint16(syncCode)
+	if header.SyncCode != SyncCode {
+		return header, errors.New("incorrect frame sync code")
+	}
 
 	// reserved
 	reserved, err := reader.ReadBool()
@@ -245,18 +351,12 @@ func readFrameHeader(reader *bitio.Reader) (*FrameHeader, error) {
 	//   <8-56>:"UTF-8" coded sample number (decoded number is 36 bits)
 	// else
 	//   <8-48>:"UTF-8" coded frame number (decoded number is 31 bits)
-	if header.BlockingStrategy == VariableBlockSizeStream {
-		header.VariableBlockSize, err = reader.ReadBits(36)
-		if err != nil {
-			return header, err
-		}
+	header.VariableBlockSize, err = readUTF8Number(reader)
+	if err != nil {
+		return header, err
 	}
-
-	if header.BlockingStrategy == FixedBlockSizeStream {
-		header.VariableBlockSize, err = reader.ReadBits(31)
-		if err != nil {
-			return header, err
-		}
+	if header.BlockingStrategy == FixedBlockSizeStream && header.VariableBlockSize >= 1<<31 {
+		return header, errors.New("incorrect frame number")
 	}
 
 	// block size
@@ -300,11 +400,170 @@ func readFrameHeader(reader *bitio.Reader) (*FrameHeader, error) {
 	}
 
 	// CRC-8
+	computed := reader.crc8
 	crc, err := reader.ReadBits(8)
 	if err != nil {
 		return header, err
 	}
 	header.CRC8 = uint8(crc)
+	if header.CRC8 != computed {
+		return header, &CRCError{
+			FrameNumber: header.VariableBlockSize,
+			Offset:      -1,
+			Stored:      uint16(header.CRC8),
+			Computed:    uint16(computed),
+		}
+	}
 
 	return header, nil
 }
+
+// read "UTF-8" coded number of 1-7 bytes:
+// 0xxxxxxx
+// 110xxxxx 10xxxxxx
+// 1110xxxx 10xxxxxx 10xxxxxx
+// ...
+// 11111110 10xxxxxx 10xxxxxx 10xxxxxx 10xxxxxx 10xxxxxx 10xxxxxx
+func readUTF8Number(reader *frameReader) (uint64, error) {
+	first, err := reader.ReadBits(8)
+	if err != nil {
+		return 0, err
+	}
+
+	// number of leading ones is the length of the sequence
+	var length int
+	for mask := uint64(0x80); first&mask != 0; mask >>= 1 {
+		length++
+	}
+	switch {
+	case length == 0:
+		return first, nil
+	case length == 1 || length > 7:
+		return 0, errors.New("incorrect UTF-8 coded number")
+	}
+
+	number := first & (0xFF >> uint(length+1))
+	for i := 1; i < length; i++ {
+		next, err := reader.ReadBits(8)
+		if err != nil {
+			return 0, err
+		}
+		if next&0xC0 != 0x80 {
+			return 0, errors.New("incorrect UTF-8 coded number")
+		}
+		number = number<<6 | next&0x3F
+	}
+	return number, nil
+}
+
+// create header of encoded frame, the values are stored in the header codes where possible
+func NewFrameHeader(blockSize uint32, sampleRate uint32, bitsPerSample uint8, channelAssigment ChannelAssigment) FrameHeader {
+	header := FrameHeader{
+		SyncCode:         SyncCode,
+		ChannelAssigment: channelAssigment,
+	}
+
+	// block size
+	header.BlockSize = 0
+	for code := BlockSize(1); code < 16; code++ {
+		if code.BlockSize() == blockSize {
+			header.BlockSize = code
+			break
+		}
+	}
+	if header.BlockSize == 0 {
+		header.BlockSize = 7
+		if blockSize <= 1<<8 {
+			header.BlockSize = 6
+		}
+		header.BlockSizeEnd = uint16(blockSize - 1)
+	}
+
+	// sample rate
+	for code := SampleRate(1); code < 12; code++ {
+		if code.SampleRate() == sampleRate {
+			header.SampleRate = code
+			break
+		}
+	}
+	if header.SampleRate == 0 {
+		switch {
+		case sampleRate%1000 == 0 && sampleRate/1000 < 1<<8:
+			header.SampleRate = 12
+			header.SampleRateEnd = uint16(sampleRate / 1000)
+		case sampleRate < 1<<16:
+			header.SampleRate = 13
+			header.SampleRateEnd = uint16(sampleRate)
+		case sampleRate%10 == 0 && sampleRate/10 < 1<<16:
+			header.SampleRate = 14
+			header.SampleRateEnd = uint16(sampleRate / 10)
+		}
+	}
+
+	// sample size, get from STREAMINFO if there is no code
+	for code := SampleSize(1); code < 8; code++ {
+		if code.SampleSize() == bitsPerSample {
+			header.SampleSize = code
+			break
+		}
+	}
+
+	return header
+}
+
+// write frame header without CRC-8
+func writeFrameHeader(writer *bitio.Writer, header *FrameHeader) error {
+	writer.TryWriteBits(uint64(header.SyncCode), 14)
+	writer.TryWriteBool(bool(header.Reserved))
+	writer.TryWriteBool(bool(header.BlockingStrategy))
+	writer.TryWriteBits(uint64(header.BlockSize), 4)
+	writer.TryWriteBits(uint64(header.SampleRate), 4)
+	writer.TryWriteBits(uint64(header.ChannelAssigment), 4)
+	writer.TryWriteBits(uint64(header.SampleSize), 3)
+	writer.TryWriteBool(bool(header.Reserved2))
+
+	err := writeUTF8Number(writer, header.VariableBlockSize)
+	if err != nil {
+		return err
+	}
+
+	switch header.BlockSize {
+	case 6:
+		writer.TryWriteBits(uint64(header.BlockSizeEnd), 8)
+	case 7:
+		writer.TryWriteBits(uint64(header.BlockSizeEnd), 16)
+	}
+
+	switch header.SampleRate {
+	case 12:
+		writer.TryWriteBits(uint64(header.SampleRateEnd), 8)
+	case 13, 14:
+		writer.TryWriteBits(uint64(header.SampleRateEnd), 16)
+	}
+
+	return writer.TryError
+}
+
+// write "UTF-8" coded number of 1-7 bytes, see readUTF8Number
+func writeUTF8Number(writer *bitio.Writer, number uint64) error {
+	if number < 0x80 {
+		writer.TryWriteBits(number, 8)
+		return writer.TryError
+	}
+	if number >= 1<<36 {
+		return errors.New("number is too large for UTF-8 coding")
+	}
+
+	// each continuation byte holds 6 bits, the first byte holds 6-length bits
+	length := 2
+	for number >= 1<<uint(5*length+1) && length < 7 {
+		length++
+	}
+
+	prefix := uint64(0xFF00>>uint(length)) & 0xFF
+	writer.TryWriteBits(prefix|number>>uint(6*(length-1)), 8)
+	for i := length - 2; i >= 0; i-- {
+		writer.TryWriteBits(0x80|(number>>uint(6*i))&0x3F, 8)
+	}
+	return writer.TryError
+}
@@ -0,0 +1,253 @@
+package frame
+
+import "math"
+
+// maximum LPC order of subframe
+const MaxLPCOrder = 32
+
+// maximum precision of quantized LPC coefficients in bits
+const MaxQLPPrecision = 15
+
+// Window returns apodization window of n samples, applied to the samples before autocorrelation
+type Window func(n int) []float64
+
+// window of ones
+func RectangleWindow() Window {
+	return func(n int) []float64 {
+		window := make([]float64, n)
+		for i := range window {
+			window[i] = 1
+		}
+		return window
+	}
+}
+
+// window tapered by cosine lobes, p is the tapered fraction of the window:
+// 0 is the rectangle window, 1 is the Hann window
+func TukeyWindow(p float64) Window {
+	return func(n int) []float64 {
+		return tukey(n, 0, n, p)
+	}
+}
+
+// windows where only one of the parts of the block is a Tukey window and the rest is zero
+func PartialTukeyWindows(parts int, p float64) []Window {
+	windows := make([]Window, parts)
+	for i := range windows {
+		part := i
+		windows[i] = func(n int) []float64 {
+			return tukey(n, n*part/parts, n*(part+1)/parts, p)
+		}
+	}
+	return windows
+}
+
+// windows where one of the parts of the block is punched out of the Tukey window
+func PunchoutTukeyWindows(parts int, p float64) []Window {
+	windows := make([]Window, parts)
+	for i := range windows {
+		part := i
+		windows[i] = func(n int) []float64 {
+			start, end := n*part/parts, n*(part+1)/parts
+			window := tukey(n, 0, start, p)
+			rest := tukey(n, end, n, p)
+			for j := end; j < n; j++ {
+				window[j] = rest[j]
+			}
+			return window
+		}
+	}
+	return windows
+}
+
+// Tukey window and windows of the block divided into 2, 3 ... parts parts, like subdivide_tukey of the reference encoder
+func SubdivideTukeyWindows(parts int, p float64) []Window {
+	windows := []Window{TukeyWindow(p)}
+	for i := 2; i <= parts; i++ {
+		windows = append(windows, PartialTukeyWindows(i, p)...)
+		windows = append(windows, PunchoutTukeyWindows(i, p)...)
+	}
+	return windows
+}
+
+// Tukey window on [start, end) of n samples, zero elsewhere
+func tukey(n int, start int, end int, p float64) []float64 {
+	window := make([]float64, n)
+	length := end - start
+	if length <= 0 {
+		return window
+	}
+	if p > 1 {
+		p = 1
+	}
+
+	// number of samples in each tapered lobe
+	taper := 0
+	if p > 0 {
+		taper = int(p / 2 * float64(length))
+	}
+	for i := 0; i < length; i++ {
+		value := 1.0
+		switch {
+		case i < taper:
+			value = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		case i >= length-taper:
+			value = 0.5 - 0.5*math.Cos(math.Pi*float64(length-1-i)/float64(taper))
+		}
+		window[start+i] = value
+	}
+	return window
+}
+
+// return autocorrelation of windowed samples for lags 0..maxLag
+func autocorrelation(samples []int32, window []float64, maxLag int) []float64 {
+	windowed := make([]float64, len(samples))
+	for i, sample := range samples {
+		windowed[i] = float64(sample) * window[i]
+	}
+
+	autoc := make([]float64, maxLag+1)
+	for lag := range autoc {
+		var sum float64
+		for i := lag; i < len(windowed); i++ {
+			sum += windowed[i] * windowed[i-lag]
+		}
+		autoc[lag] = sum
+	}
+	return autoc
+}
+
+// Levinson-Durbin recursion
+// returns prediction coefficients of orders 1..maxOrder: samples[i] ~ sum(coefficients[order-1][j] * samples[i-j-1])
+// and prediction error of each order; the result is shorter if the signal is predicted without error
+func levinsonDurbin(autoc []float64, maxOrder int) ([][]float64, []float64) {
+	var coefficients [][]float64
+	var predictionErrors []float64
+
+	err := autoc[0]
+	lpc := make([]float64, 0, maxOrder)
+	for order := 1; order <= maxOrder && err > 0; order++ {
+		// reflection coefficient
+		reflection := autoc[order]
+		for j, c := range lpc {
+			reflection -= c * autoc[order-j-1]
+		}
+		reflection /= err
+
+		next := make([]float64, order)
+		for j, c := range lpc {
+			next[j] = c - reflection*lpc[order-j-2]
+		}
+		next[order-1] = reflection
+		lpc = next
+
+		err *= 1 - reflection*reflection
+		if err < 0 {
+			err = 0
+		}
+		coefficients = append(coefficients, lpc)
+		predictionErrors = append(predictionErrors, err)
+	}
+	return coefficients, predictionErrors
+}
+
+// quantize coefficients to precision bits, returns quantized coefficients and shift
+// returns false if the coefficients can not be quantized
+func quantizeCoefficients(coefficients []float64, precision uint8) ([]int32, int8, bool) {
+	qmax := int64(1)<<(precision-1) - 1
+	qmin := -qmax - 1
+
+	var cmax float64
+	for _, c := range coefficients {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax <= 0 || math.IsNaN(cmax) || math.IsInf(cmax, 0) {
+		return nil, 0, false
+	}
+
+	// the largest coefficient uses all bits of precision, cmax < 2^log2cmax
+	_, log2cmax := math.Frexp(cmax)
+	shift := int(precision) - 1 - log2cmax
+	if shift > 15 {
+		shift = 15
+	}
+
+	// negative shift is not allowed, the coefficients are scaled down instead
+	scale := math.Ldexp(1, shift)
+	if shift < 0 {
+		shift = 0
+	}
+
+	// quantization error is carried to the next coefficient
+	quantized := make([]int32, len(coefficients))
+	var carry float64
+	for i, c := range coefficients {
+		carry += c * scale
+		q := int64(math.Round(carry))
+		if q > qmax {
+			q = qmax
+		} else if q < qmin {
+			q = qmin
+		}
+		carry -= float64(q)
+		quantized[i] = int32(q)
+	}
+	return quantized, int8(shift), true
+}
+
+// return precision of quantized LPC coefficients like the reference encoder
+func defaultQLPPrecision(bitsPerSample uint8, blockSize int, order int) uint8 {
+	var precision uint8
+	switch {
+	case bitsPerSample > 16 && blockSize <= 384:
+		precision = MaxQLPPrecision - 2
+	case bitsPerSample > 16 && blockSize <= 1152:
+		precision = MaxQLPPrecision - 1
+	case bitsPerSample > 16:
+		precision = MaxQLPPrecision
+	case blockSize <= 192:
+		precision = 7
+	case blockSize <= 384:
+		precision = 8
+	case blockSize <= 576:
+		precision = 9
+	case blockSize <= 1152:
+		precision = 10
+	case blockSize <= 2304:
+		precision = 11
+	case blockSize <= 4608:
+		precision = 12
+	default:
+		precision = 13
+	}
+
+	// keep prediction in 32 bits where possible
+	if bitsPerSample <= 17 {
+		limit := 32 - int(bitsPerSample) - ilog2(order)
+		if limit < int(precision) {
+			precision = uint8(limit)
+		}
+	}
+	return precision
+}
+
+// return expected number of bits per residual sample for prediction error of block
+func expectedBitsPerResidual(err float64, blockSize int) float64 {
+	if err <= 0 {
+		return 0
+	}
+	bitsPerSample := 0.5 * math.Log2(0.5*err/float64(blockSize))
+	if bitsPerSample < 0 {
+		return 0
+	}
+	return bitsPerSample
+}
+
+func ilog2(n int) int {
+	log := 0
+	for n > 1 {
+		n >>= 1
+		log++
+	}
+	return log
+}
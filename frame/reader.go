@@ -0,0 +1,77 @@
+package frame
+
+import (
+	"errors"
+	"github.com/icza/bitio"
+)
+
+// frameReader reads bits of one frame, counts them and computes the frame CRCs
+type frameReader struct {
+	reader  *bitio.Reader
+	bits    uint64 // number of bits read since the frame start
+	partial uint8  // bits of the current incomplete byte
+	crc8    uint8  // CRC-8 of the complete bytes read
+	crc16   uint16 // CRC-16 of the complete bytes read
+}
+
+func newFrameReader(reader *bitio.Reader) *frameReader {
+	return &frameReader{reader: reader}
+}
+
+func (fr *frameReader) ReadBits(n uint8) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	value, err := fr.reader.ReadBits(n)
+	if err != nil {
+		return 0, err
+	}
+	fr.update(value, n)
+	return value, nil
+}
+
+func (fr *frameReader) ReadBool() (bool, error) {
+	bit, err := fr.reader.ReadBool()
+	if err != nil {
+		return false, err
+	}
+	var value uint64
+	if bit {
+		value = 1
+	}
+	fr.update(value, 1)
+	return bit, nil
+}
+
+// add the lowest n bits of value to the read bits
+func (fr *frameReader) update(value uint64, n uint8) {
+	for n > 0 {
+		// bits to complete the current byte
+		k := 8 - uint8(fr.bits%8)
+		if k > n {
+			k = n
+		}
+		n -= k
+		fr.partial = fr.partial<<k | uint8(value>>n)&(1<<k-1)
+		fr.bits += uint64(k)
+
+		if fr.bits%8 == 0 {
+			fr.crc8 = updateCRC8(fr.crc8, fr.partial)
+			fr.crc16 = updateCRC16(fr.crc16, fr.partial)
+			fr.partial = 0
+		}
+	}
+}
+
+// skip zero bit padding up to the byte boundary
+func (fr *frameReader) align() error {
+	padding := uint8((8 - fr.bits%8) % 8)
+	zero, err := fr.ReadBits(padding)
+	if err != nil {
+		return err
+	}
+	if zero != 0 {
+		return errors.New("incorrect frame zero bit padding")
+	}
+	return nil
+}
@@ -0,0 +1,387 @@
+package frame
+
+import (
+	"errors"
+	"github.com/icza/bitio"
+	"math"
+	"math/bits"
+)
+
+type Residual struct {
+	// Residual coding method:
+	// 00 : partitioned Rice coding with 4-bit Rice parameter; RESIDUAL_CODING_METHOD_PARTITIONED_RICE follows
+	// 01 : partitioned Rice coding with 5-bit Rice parameter; RESIDUAL_CODING_METHOD_PARTITIONED_RICE2 follows
+	// 10-11 : reserved
+	CodingMethod ResidualCodingMethod
+
+	// Partition order. There will be 2^order partitions.
+	PartitionOrder uint8
+
+	Partitions []RicePartition
+}
+
+type RicePartition struct {
+	// Encoding parameter:
+	// 0000-1110 (RICE) or 00000-11110 (RICE2) : Rice parameter
+	// 1111 (RICE) or 11111 (RICE2) : Escape code, meaning the partition is in unencoded binary form
+	Parameter uint8
+
+	// if the partition is escaped: <5> bits per sample of unencoded binary
+	EscapeBitsPerSample uint8
+}
+
+type ResidualCodingMethod uint8
+
+const (
+	RiceCodingMethod  ResidualCodingMethod = 0
+	Rice2CodingMethod ResidualCodingMethod = 1
+)
+
+// return size of Rice parameter in bits
+func (cm ResidualCodingMethod) ParameterSize() uint8 {
+	switch cm {
+	case RiceCodingMethod:
+		return 4
+	case Rice2CodingMethod:
+		return 5
+	}
+	return 0
+}
+
+// return escape code of Rice parameter
+func (cm ResidualCodingMethod) EscapeCode() uint8 {
+	return 1<<cm.ParameterSize() - 1
+}
+
+// return true if the partition is in unencoded binary form
+func (rp *RicePartition) IsEscaped(method ResidualCodingMethod) bool {
+	return rp.Parameter == method.EscapeCode()
+}
+
+// read residual of FIXED and LPC subframes into samples[order:]
+func readResidual(reader *frameReader, samples []int32, order int) (*Residual, error) {
+	residual := &Residual{}
+
+	// residual coding method
+	method, err := reader.ReadBits(2)
+	if err != nil {
+		return residual, err
+	}
+	residual.CodingMethod = ResidualCodingMethod(method)
+	parameterSize := residual.CodingMethod.ParameterSize()
+	if parameterSize == 0 {
+		return residual, errors.New("reserved residual coding method")
+	}
+
+	// partition order
+	partitionOrder, err := reader.ReadBits(4)
+	if err != nil {
+		return residual, err
+	}
+	residual.PartitionOrder = uint8(partitionOrder)
+
+	// number of samples in each partition:
+	// first partition contains blockSize/2^order - predictor order samples, the others blockSize/2^order
+	blockSize := len(samples)
+	numberOfPartitions := 1 << residual.PartitionOrder
+	partitionSize := blockSize >> residual.PartitionOrder
+	if partitionSize<<residual.PartitionOrder != blockSize {
+		return residual, errors.New("block size is not divisible by the number of residual partitions")
+	}
+	if partitionSize < order {
+		return residual, errors.New("predictor order does not fit the first residual partition")
+	}
+
+	residual.Partitions = make([]RicePartition, numberOfPartitions)
+	i := order
+	for p := range residual.Partitions {
+		partition := &residual.Partitions[p]
+		end := (p + 1) * partitionSize
+
+		parameter, err := reader.ReadBits(parameterSize)
+		if err != nil {
+			return residual, err
+		}
+		partition.Parameter = uint8(parameter)
+
+		if partition.IsEscaped(residual.CodingMethod) {
+			// unencoded binary, zero bit width means all residuals are zero
+			bitsPerSample, err := reader.ReadBits(5)
+			if err != nil {
+				return residual, err
+			}
+			partition.EscapeBitsPerSample = uint8(bitsPerSample)
+			for ; i < end; i++ {
+				samples[i], err = readSigned(reader, partition.EscapeBitsPerSample)
+				if err != nil {
+					return residual, err
+				}
+			}
+			continue
+		}
+
+		for ; i < end; i++ {
+			samples[i], err = readRice(reader, partition.Parameter)
+			if err != nil {
+				return residual, err
+			}
+		}
+	}
+
+	return residual, nil
+}
+
+// read Rice coded signed value:
+// quotient unary coded (zeros terminated by one), then k bits of remainder, then zigzag folded sign
+func readRice(reader *frameReader, k uint8) (int32, error) {
+	var quotient uint64
+	for {
+		bit, err := reader.ReadBool()
+		if err != nil {
+			return 0, err
+		}
+		if bit {
+			break
+		}
+		quotient++
+	}
+
+	var remainder uint64
+	if k > 0 {
+		var err error
+		remainder, err = reader.ReadBits(k)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	folded := uint32(quotient<<k | remainder)
+	return int32(folded>>1) ^ -int32(folded&1), nil
+}
+
+// write residual of FIXED and LPC subframes
+// values - blockSize-order residual values
+func writeResidual(writer *bitio.Writer, residual *Residual, values []int32, blockSize int, order int) error {
+	parameterSize := residual.CodingMethod.ParameterSize()
+	if parameterSize == 0 {
+		return errors.New("reserved residual coding method")
+	}
+	numberOfPartitions := 1 << residual.PartitionOrder
+	partitionSize := blockSize >> residual.PartitionOrder
+	if len(residual.Partitions) != numberOfPartitions || partitionSize<<residual.PartitionOrder != blockSize || partitionSize < order {
+		return errors.New("incorrect residual partitions")
+	}
+
+	writer.TryWriteBits(uint64(residual.CodingMethod), 2)
+	writer.TryWriteBits(uint64(residual.PartitionOrder), 4)
+
+	start := 0
+	for p, partition := range residual.Partitions {
+		end := (p+1)*partitionSize - order
+		writer.TryWriteBits(uint64(partition.Parameter), parameterSize)
+
+		if partition.IsEscaped(residual.CodingMethod) {
+			writer.TryWriteBits(uint64(partition.EscapeBitsPerSample), 5)
+			if partition.EscapeBitsPerSample > 0 {
+				for _, value := range values[start:end] {
+					writer.TryWriteBits(uint64(value), partition.EscapeBitsPerSample)
+				}
+			}
+		} else {
+			for _, value := range values[start:end] {
+				writeRice(writer, value, partition.Parameter)
+			}
+		}
+		if writer.TryError != nil {
+			return writer.TryError
+		}
+		start = end
+	}
+
+	return nil
+}
+
+// write Rice coded signed value, see readRice
+func writeRice(writer *bitio.Writer, value int32, k uint8) {
+	folded := uint64(fold(value))
+	quotient := folded >> k
+
+	// quotient zeros and the terminating one
+	for ; quotient >= 32; quotient -= 32 {
+		writer.TryWriteBits(0, 32)
+	}
+	writer.TryWriteBits(1, uint8(quotient)+1)
+
+	if k > 0 {
+		writer.TryWriteBits(folded, k)
+	}
+}
+
+// zigzag fold signed value to unsigned: 0, -1, 1, -2, 2 ... => 0, 1, 2, 3, 4 ...
+func fold(value int32) uint32 {
+	return uint32(value<<1) ^ uint32(value>>31)
+}
+
+// maximum partition order of residual
+const MaxPartitionOrder = 15
+
+// maximum Rice parameter of RICE coding method, greater parameters need RICE2
+const maxRiceParameter = 14
+
+// maximum Rice parameter of RICE2 coding method
+const maxRice2Parameter = 30
+
+// statistics of residual partition
+type partitionStats struct {
+	count     int    // number of values
+	sum       uint64 // sum of folded values
+	maxFolded uint32 // maximum folded value
+}
+
+// choose Rice coding of residual values with the smallest size and return it with its size in bits
+// partition orders 0..maxPartitionOrder allowed by the block size and predictor order are searched,
+// RICE2 is used if a Rice parameter is greater than 14, escape code is used if unencoded binary is smaller
+func encodeResidual(values []int32, blockSize int, order int, maxPartitionOrder int) (Residual, uint64) {
+	// the greatest partition order: block size is divisible by the number of partitions,
+	// partitions are longer than predictor order
+	partitionOrder := maxPartitionOrder
+	if partitionOrder > MaxPartitionOrder {
+		partitionOrder = MaxPartitionOrder
+	}
+	for partitionOrder > 0 && (blockSize%(1<<uint(partitionOrder)) != 0 || blockSize>>uint(partitionOrder) <= order) {
+		partitionOrder--
+	}
+
+	// statistics of the smallest partitions
+	partitionSize := blockSize >> uint(partitionOrder)
+	stats := make([]partitionStats, 1<<uint(partitionOrder))
+	for i, value := range values {
+		partition := &stats[(i+order)/partitionSize]
+		folded := fold(value)
+		partition.count++
+		partition.sum += uint64(folded)
+		if folded > partition.maxFolded {
+			partition.maxFolded = folded
+		}
+	}
+
+	var best Residual
+	var bestSize uint64
+	for ; partitionOrder >= 0; partitionOrder-- {
+		residual, size := encodePartitions(stats)
+		residual.PartitionOrder = uint8(partitionOrder)
+		if best.Partitions == nil || size < bestSize {
+			best, bestSize = residual, size
+		}
+
+		// merge pairs of partitions for the lower order
+		for i := 0; i < len(stats)/2; i++ {
+			first, second := stats[2*i], stats[2*i+1]
+			stats[i] = partitionStats{
+				count:     first.count + second.count,
+				sum:       first.sum + second.sum,
+				maxFolded: first.maxFolded,
+			}
+			if second.maxFolded > first.maxFolded {
+				stats[i].maxFolded = second.maxFolded
+			}
+		}
+		stats = stats[:len(stats)/2]
+	}
+
+	// estimated sizes choose the coding, the exact size is returned
+	return best, residualSize(&best, values, blockSize, order)
+}
+
+// choose coding method and parameters of partitions, returns estimated size in bits
+func encodePartitions(stats []partitionStats) (Residual, uint64) {
+	rice := Residual{CodingMethod: RiceCodingMethod, Partitions: make([]RicePartition, len(stats))}
+	rice2 := Residual{CodingMethod: Rice2CodingMethod, Partitions: make([]RicePartition, len(stats))}
+
+	// coding method and partition order
+	riceTotal := uint64(2 + 4)
+	rice2Total := uint64(2 + 4)
+	needRice2 := false
+	for i, partition := range stats {
+		size, parameter := partition.riceSize(maxRiceParameter)
+		rice.Partitions[i] = RicePartition{Parameter: parameter}
+		riceTotal += 4 + size
+
+		size2, parameter2 := partition.riceSize(maxRice2Parameter)
+		rice2.Partitions[i] = RicePartition{Parameter: parameter2}
+		rice2Total += 5 + size2
+		if parameter2 > maxRiceParameter {
+			needRice2 = true
+		}
+
+		// escape code with unencoded binary of the smallest width
+		escapeBits := uint8(bits.Len32(partition.maxFolded))
+		if escapeBits < 32 {
+			escapeSize := 5 + uint64(partition.count)*uint64(escapeBits)
+			if escapeSize < size {
+				rice.Partitions[i] = RicePartition{Parameter: RiceCodingMethod.EscapeCode(), EscapeBitsPerSample: escapeBits}
+				riceTotal += escapeSize - size
+			}
+			if escapeSize < size2 {
+				rice2.Partitions[i] = RicePartition{Parameter: Rice2CodingMethod.EscapeCode(), EscapeBitsPerSample: escapeBits}
+				rice2Total += escapeSize - size2
+			}
+		}
+	}
+
+	if needRice2 && rice2Total < riceTotal {
+		return rice2, rice2Total
+	}
+	return rice, riceTotal
+}
+
+// return estimated size in bits of Rice coded values of partition with the best parameter not greater than maxParameter
+func (ps *partitionStats) riceSize(maxParameter uint8) (uint64, uint8) {
+	n := uint64(ps.count)
+	if n == 0 {
+		return 0, 0
+	}
+
+	// the best parameter is close to log2 of the mean folded value
+	var estimate uint8
+	if ps.sum > n {
+		estimate = uint8(bits.Len64(ps.sum/n)) - 1
+	}
+	if estimate > maxParameter {
+		estimate = maxParameter
+	}
+
+	bestParameter, bestSize := uint8(0), uint64(math.MaxUint64)
+	for k := int(estimate) - 1; k <= int(estimate)+1; k++ {
+		if k < 0 || k > int(maxParameter) {
+			continue
+		}
+		// unary coded quotients with terminating ones and k bits of remainders
+		size := n*uint64(k+1) + ps.sum>>uint(k)
+		if size < bestSize {
+			bestParameter, bestSize = uint8(k), size
+		}
+	}
+	return bestSize, bestParameter
+}
+
+// return exact size in bits of the coded residual
+func residualSize(residual *Residual, values []int32, blockSize int, order int) uint64 {
+	partitionSize := blockSize >> residual.PartitionOrder
+	size := uint64(2 + 4)
+	start := 0
+	for p, partition := range residual.Partitions {
+		end := (p+1)*partitionSize - order
+		size += uint64(residual.CodingMethod.ParameterSize())
+		if partition.IsEscaped(residual.CodingMethod) {
+			size += 5 + uint64(end-start)*uint64(partition.EscapeBitsPerSample)
+		} else {
+			size += uint64(end-start) * uint64(partition.Parameter+1)
+			for _, value := range values[start:end] {
+				size += uint64(fold(value) >> partition.Parameter)
+			}
+		}
+		start = end
+	}
+	return size
+}
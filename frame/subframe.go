@@ -0,0 +1,482 @@
+package frame
+
+import (
+	"errors"
+	"github.com/icza/bitio"
+	"math"
+)
+
+type Subframe struct {
+	Header SubframeHeader
+
+	// FIXED and LPC: unencoded warm-up samples (order * bits per sample)
+	Warmup []int32
+
+	// LPC: quantized linear predictor coefficients precision in bits
+	QLPPrecision uint8
+
+	// LPC: quantized linear predictor coefficient shift needed in bits
+	QLPShift int8
+
+	// LPC: unencoded predictor coefficients (order * QLPPrecision)
+	QLPCoefficients []int32
+
+	// FIXED and LPC: encoded residual
+	Residual Residual
+
+	// decoded samples of the channel
+	Samples []int32
+}
+
+type SubframeHeader struct {
+	// Zero bit padding, to prevent sync-fooling string of 1s
+	ZeroPadding bool
+
+	// Subframe type:
+	// 000000 : SUBFRAME_CONSTANT
+	// 000001 : SUBFRAME_VERBATIM
+	// 00001x : reserved
+	// 0001xx : reserved
+	// 001xxx : if(xxx <= 4) SUBFRAME_FIXED, xxx=order ; else reserved
+	// 01xxxx : reserved
+	// 1xxxxx : SUBFRAME_LPC, xxxxx=order-1
+	Type SubframeType
+
+	// 'Wasted bits-per-sample' flag:
+	// 0 : no wasted bits-per-sample in source subblock, k=0
+	// 1 : k wasted bits-per-sample in source subblock, k-1 follows, unary coded; e.g. k=3 => 001 follows, k=7 => 0000001 follows.
+	WastedBits uint8
+}
+
+type SubframeType uint8
+
+const (
+	ConstantSubframe SubframeType = 0
+	VerbatimSubframe SubframeType = 1
+	FixedSubframe    SubframeType = 8
+	LPCSubframe      SubframeType = 32
+)
+
+// return kind of subframe (CONSTANT, VERBATIM, FIXED or LPC) without predictor order
+func (st SubframeType) Kind() SubframeType {
+	switch {
+	case st >= LPCSubframe:
+		return LPCSubframe
+	case st >= FixedSubframe && st <= FixedSubframe+4:
+		return FixedSubframe
+	}
+	return st
+}
+
+// return predictor order for FIXED and LPC subframes
+func (st SubframeType) Order() int {
+	switch st.Kind() {
+	case FixedSubframe:
+		return int(st - FixedSubframe)
+	case LPCSubframe:
+		return int(st-LPCSubframe) + 1
+	}
+	return 0
+}
+
+func (st SubframeType) String() string {
+	switch st.Kind() {
+	case ConstantSubframe:
+		return "CONSTANT"
+	case VerbatimSubframe:
+		return "VERBATIM"
+	case FixedSubframe:
+		return "FIXED"
+	case LPCSubframe:
+		return "LPC"
+	}
+	return "reserved"
+}
+
+// read subframe of one channel
+// blockSize - number of samples in subframe
+// bitsPerSample - sample size of the channel (including the extra bit of side channel)
+// returns the subframe and its samples, Samples and Warmup of the subframe are truncated to 32 bits,
+// which matters only for the 33 bit side channel of 32 bit stereo
+func readSubframe(reader *frameReader, blockSize int, bitsPerSample uint8) (*Subframe, []int64, error) {
+	header, err := readSubframeHeader(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	subframe := &Subframe{Header: *header}
+
+	if header.WastedBits >= bitsPerSample {
+		return subframe, nil, errors.New("incorrect subframe wasted bits")
+	}
+	bitsPerSample -= header.WastedBits
+
+	samples := make([]int64, blockSize)
+	switch header.Type.Kind() {
+	case ConstantSubframe:
+		err = readConstant(reader, samples, bitsPerSample)
+	case VerbatimSubframe:
+		err = readVerbatim(reader, samples, bitsPerSample)
+	case FixedSubframe:
+		err = subframe.readFixed(reader, samples, bitsPerSample)
+	case LPCSubframe:
+		err = subframe.readLPC(reader, samples, bitsPerSample)
+	default:
+		err = errors.New("reserved subframe type")
+	}
+	if err != nil {
+		return subframe, nil, err
+	}
+
+	subframe.Samples = make([]int32, blockSize)
+	for i := range samples {
+		samples[i] <<= header.WastedBits
+		subframe.Samples[i] = int32(samples[i])
+	}
+	return subframe, samples, nil
+}
+
+func readSubframeHeader(reader *frameReader) (*SubframeHeader, error) {
+	header := &SubframeHeader{}
+
+	// zero bit padding
+	zeroPadding, err := reader.ReadBool()
+	if err != nil {
+		return header, err
+	}
+	if zeroPadding {
+		return header, errors.New("incorrect subframe zero bit padding")
+	}
+	header.ZeroPadding = zeroPadding
+
+	// subframe type
+	subframeType, err := reader.ReadBits(6)
+	if err != nil {
+		return header, err
+	}
+	header.Type = SubframeType(subframeType)
+
+	// wasted bits-per-sample flag
+	hasWastedBits, err := reader.ReadBool()
+	if err != nil {
+		return header, err
+	}
+	if hasWastedBits {
+		// k-1 unary coded
+		header.WastedBits = 1
+		for {
+			bit, err := reader.ReadBool()
+			if err != nil {
+				return header, err
+			}
+			if bit {
+				break
+			}
+			header.WastedBits++
+		}
+	}
+
+	return header, nil
+}
+
+// SUBFRAME_CONSTANT
+// <n> Unencoded constant value of the subblock, n = frame's bits-per-sample.
+func readConstant(reader *frameReader, samples []int64, bitsPerSample uint8) error {
+	value, err := readWideSigned(reader, bitsPerSample)
+	if err != nil {
+		return err
+	}
+
+	for i := range samples {
+		samples[i] = value
+	}
+	return nil
+}
+
+// SUBFRAME_VERBATIM
+// <n*i> Unencoded subblock; n = frame's bits-per-sample, i = frame's blocksize.
+func readVerbatim(reader *frameReader, samples []int64, bitsPerSample uint8) error {
+	for i := range samples {
+		sample, err := readWideSigned(reader, bitsPerSample)
+		if err != nil {
+			return err
+		}
+		samples[i] = sample
+	}
+	return nil
+}
+
+// SUBFRAME_FIXED
+// <n> Unencoded warm-up samples (n = frame's bits-per-sample * predictor order).
+// RESIDUAL Encoded residual
+func (s *Subframe) readFixed(reader *frameReader, samples []int64, bitsPerSample uint8) error {
+	order := s.Header.Type.Order()
+	if order > len(samples) {
+		return errors.New("incorrect FIXED subframe predictor order")
+	}
+
+	err := s.readWarmup(reader, samples[:order], bitsPerSample)
+	if err != nil {
+		return err
+	}
+
+	residual := make([]int32, len(samples))
+	decoded, err := readResidual(reader, residual, order)
+	if err != nil {
+		return err
+	}
+	s.Residual = *decoded
+
+	restoreFixed(samples, residual, order)
+	return nil
+}
+
+// SUBFRAME_LPC
+// <n> Unencoded warm-up samples (n = frame's bits-per-sample * lpc order).
+// <4> (Quantized linear predictor coefficients' precision in bits)-1 (1111 = invalid, to prevent sync-fooling string of 1s).
+// <5> Quantized linear predictor coefficient shift needed in bits (NOTE: this number is signed two's-complement).
+// <n> Unencoded predictor coefficients (n = qlp coeff precision * lpc order) (NOTE: the coefficients are signed two's-complement).
+// RESIDUAL Encoded residual
+func (s *Subframe) readLPC(reader *frameReader, samples []int64, bitsPerSample uint8) error {
+	order := s.Header.Type.Order()
+	if order > len(samples) {
+		return errors.New("incorrect LPC subframe predictor order")
+	}
+
+	err := s.readWarmup(reader, samples[:order], bitsPerSample)
+	if err != nil {
+		return err
+	}
+
+	err = s.readLPCCoefficients(reader, order)
+	if err != nil {
+		return err
+	}
+
+	residual := make([]int32, len(samples))
+	decoded, err := readResidual(reader, residual, order)
+	if err != nil {
+		return err
+	}
+	s.Residual = *decoded
+
+	restoreLPC(samples, residual, s.QLPCoefficients, s.QLPShift)
+	return nil
+}
+
+// read precision, shift and coefficients of LPC subframe
+func (s *Subframe) readLPCCoefficients(reader *frameReader, order int) error {
+	// quantized linear predictor coefficients precision
+	precision, err := reader.ReadBits(4)
+	if err != nil {
+		return err
+	}
+	if precision == 15 {
+		return errors.New("invalid LPC subframe coefficients precision")
+	}
+	s.QLPPrecision = uint8(precision) + 1
+
+	// quantized linear predictor coefficient shift
+	shift, err := readSigned(reader, 5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return errors.New("negative LPC subframe coefficient shift")
+	}
+	s.QLPShift = int8(shift)
+
+	// predictor coefficients
+	s.QLPCoefficients = make([]int32, order)
+	for i := range s.QLPCoefficients {
+		s.QLPCoefficients[i], err = readSigned(reader, s.QLPPrecision)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read warm-up samples into samples, Warmup keeps them truncated to 32 bits
+func (s *Subframe) readWarmup(reader *frameReader, samples []int64, bitsPerSample uint8) error {
+	s.Warmup = make([]int32, len(samples))
+	for i := range samples {
+		sample, err := readWideSigned(reader, bitsPerSample)
+		if err != nil {
+			return err
+		}
+		samples[i] = sample
+		s.Warmup[i] = int32(sample)
+	}
+	return nil
+}
+
+// return FIXED predictor prediction of samples[i] from the previous order samples
+func fixedPrediction(samples []int64, i int, order int) int64 {
+	switch order {
+	case 1:
+		return samples[i-1]
+	case 2:
+		return 2*samples[i-1] - samples[i-2]
+	case 3:
+		return 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+	case 4:
+		return 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+	}
+	return 0
+}
+
+// return LPC predictor prediction of samples[i] from the previous len(coefficients) samples
+func lpcPrediction(samples []int64, i int, coefficients []int32, shift int8) int64 {
+	var prediction int64
+	for j, coefficient := range coefficients {
+		prediction += int64(coefficient) * samples[i-j-1]
+	}
+	return prediction >> uint(shift)
+}
+
+// restore samples[order:] from the residual
+func restoreFixed(samples []int64, residual []int32, order int) {
+	for i := order; i < len(samples); i++ {
+		samples[i] = int64(residual[i]) + fixedPrediction(samples, i, order)
+	}
+}
+
+// restore samples[len(coefficients):] from the residual
+func restoreLPC(samples []int64, residual []int32, coefficients []int32, shift int8) {
+	for i := len(coefficients); i < len(samples); i++ {
+		samples[i] = int64(residual[i]) + lpcPrediction(samples, i, coefficients, shift)
+	}
+}
+
+// read n bits signed two's-complement value, n is up to 64
+func readWideSigned(reader *frameReader, n uint8) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	value, err := reader.ReadBits(n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(value<<(64-n)) >> (64 - n), nil
+}
+
+// read n bits signed two's-complement value, n is up to 32
+func readSigned(reader *frameReader, n uint8) (int32, error) {
+	value, err := readWideSigned(reader, n)
+	return int32(value), err
+}
+
+// write subframe of one channel
+// samples - samples of the coded channel (side channel for stereo decorrelation)
+// bitsPerSample - sample size of the channel (including the extra bit of side channel)
+func writeSubframe(writer *bitio.Writer, subframe *Subframe, samples []int32, bitsPerSample uint8) error {
+	header := &subframe.Header
+	if header.WastedBits >= bitsPerSample {
+		return errors.New("incorrect subframe wasted bits")
+	}
+
+	// header
+	writer.TryWriteBool(false)
+	writer.TryWriteBits(uint64(header.Type), 6)
+	writer.TryWriteBool(header.WastedBits > 0)
+	if header.WastedBits > 0 {
+		// k-1 unary coded
+		writer.TryWriteBits(1, header.WastedBits)
+	}
+
+	if header.WastedBits > 0 {
+		shifted := make([]int32, len(samples))
+		for i, sample := range samples {
+			shifted[i] = sample >> header.WastedBits
+		}
+		samples = shifted
+	}
+	bitsPerSample -= header.WastedBits
+
+	order := header.Type.Order()
+	switch header.Type.Kind() {
+	case ConstantSubframe:
+		writer.TryWriteBits(uint64(samples[0]), bitsPerSample)
+		return writer.TryError
+	case VerbatimSubframe:
+		for _, sample := range samples {
+			writer.TryWriteBits(uint64(sample), bitsPerSample)
+		}
+		return writer.TryError
+	case FixedSubframe, LPCSubframe:
+		if order > len(samples) {
+			return errors.New("incorrect subframe predictor order")
+		}
+	default:
+		return errors.New("reserved subframe type")
+	}
+
+	// warm-up samples
+	for _, sample := range samples[:order] {
+		writer.TryWriteBits(uint64(sample), bitsPerSample)
+	}
+
+	var residual []int32
+	var ok bool
+	if header.Type.Kind() == FixedSubframe {
+		residual, ok = fixedResidual(samples, order)
+	} else {
+		if len(subframe.QLPCoefficients) != order || subframe.QLPPrecision == 0 || subframe.QLPPrecision > 15 || subframe.QLPShift < 0 {
+			return errors.New("incorrect LPC subframe coefficients")
+		}
+		writer.TryWriteBits(uint64(subframe.QLPPrecision-1), 4)
+		writer.TryWriteBits(uint64(subframe.QLPShift), 5)
+		for _, coefficient := range subframe.QLPCoefficients {
+			writer.TryWriteBits(uint64(coefficient), subframe.QLPPrecision)
+		}
+		residual, ok = lpcResidual(samples, subframe.QLPCoefficients, subframe.QLPShift)
+	}
+	if writer.TryError != nil {
+		return writer.TryError
+	}
+	if !ok {
+		return errors.New("subframe residual does not fit 32 bits")
+	}
+
+	return writeResidual(writer, &subframe.Residual, residual, len(samples), order)
+}
+
+// return residual of FIXED predictor for samples[order:], inverse of restoreFixed
+// returns false if the residual does not fit 32 bits
+func fixedResidual(samples []int32, order int) ([]int32, bool) {
+	wide := widen(samples)
+	residual := make([]int32, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		value := wide[i] - fixedPrediction(wide, i, order)
+		if value < math.MinInt32 || value > math.MaxInt32 {
+			return residual, false
+		}
+		residual[i-order] = int32(value)
+	}
+	return residual, true
+}
+
+// return residual of LPC predictor for samples[len(coefficients):], inverse of restoreLPC
+// returns false if the residual does not fit 32 bits
+func lpcResidual(samples []int32, coefficients []int32, shift int8) ([]int32, bool) {
+	wide := widen(samples)
+	order := len(coefficients)
+	residual := make([]int32, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		value := wide[i] - lpcPrediction(wide, i, coefficients, shift)
+		if value < math.MinInt32 || value > math.MaxInt32 {
+			return residual, false
+		}
+		residual[i-order] = int32(value)
+	}
+	return residual, true
+}
+
+// return samples as int64
+func widen(samples []int32) []int64 {
+	wide := make([]int64, len(samples))
+	for i, sample := range samples {
+		wide[i] = int64(sample)
+	}
+	return wide
+}
@@ -0,0 +1,70 @@
+package flac
+
+import "frolovo22/flac/frame"
+
+// compression level of the reference encoder used by default
+const DefaultLevel = 5
+
+// return encoding options of compression level 0-8, the same as flac -0 ... -8 of the reference encoder
+// levels below 0 are level 0, levels above 8 are level 8
+// stream format fields (sample rate, channels, bits per sample) must be set by the caller,
+// any other field may be overridden
+//
+//	level  block size  LPC order  apodization          partition order  stereo
+//	0      1152        0          -                    3                independent
+//	1      1152        0          -                    3                adaptive
+//	2      1152        0          -                    3                exhaustive
+//	3      4096        6          tukey(0.5)           4                independent
+//	4      4096        8          tukey(0.5)           4                adaptive
+//	5      4096        8          tukey(0.5)           5                exhaustive
+//	6      4096        8          subdivide_tukey(2)   6                exhaustive
+//	7      4096        12         subdivide_tukey(2)   6                exhaustive
+//	8      4096        12         subdivide_tukey(3)   6                exhaustive
+func Level(level int) EncoderOptions {
+	if level < 0 {
+		level = 0
+	}
+	if level > 8 {
+		level = 8
+	}
+
+	options := EncoderOptions{
+		BlockSize:         4096,
+		Windows:           []frame.Window{frame.TukeyWindow(0.5)},
+		StereoMode:        ExhaustiveStereo,
+		MaxPartitionOrder: 6,
+	}
+	switch level {
+	case 0, 1, 2:
+		options.BlockSize = 1152
+		options.MaxLPCOrder = 0
+		options.MaxPartitionOrder = 3
+	case 3:
+		options.MaxLPCOrder = 6
+		options.MaxPartitionOrder = 4
+	case 4:
+		options.MaxLPCOrder = 8
+		options.MaxPartitionOrder = 4
+	case 5:
+		options.MaxLPCOrder = 8
+		options.MaxPartitionOrder = 5
+	case 6:
+		options.MaxLPCOrder = 8
+		options.Windows = frame.SubdivideTukeyWindows(2, 0.5)
+	case 7:
+		options.MaxLPCOrder = 12
+		options.Windows = frame.SubdivideTukeyWindows(2, 0.5)
+	case 8:
+		options.MaxLPCOrder = 12
+		options.Windows = frame.SubdivideTukeyWindows(3, 0.5)
+	}
+
+	switch level {
+	case 0, 3:
+		options.StereoMode = IndependentStereo
+	case 1, 4:
+		options.StereoMode = AdaptiveStereo
+	}
+
+	return options
+}
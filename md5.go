@@ -0,0 +1,47 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"frolovo22/flac/frame"
+	"hash"
+)
+
+var (
+	ErrMD5NotSet     = errors.New("MD5 signature is not set")
+	ErrMD5Mismatch   = errors.New("MD5 signature mismatch")
+	ErrMD5Seeked     = errors.New("MD5 signature can not be checked after seeking")
+	ErrMD5FramesRead = errors.New("MD5 signature can not be checked after frames are read")
+)
+
+// md5Hasher computes MD5 signature of the unencoded audio data:
+// samples are interleaved by channel, little-endian, sample width rounded up to whole bytes
+type md5Hasher struct {
+	hash   hash.Hash
+	format pcmFormat
+	buffer []byte
+}
+
+func newMD5Hasher(bitsPerSample uint8) *md5Hasher {
+	return &md5Hasher{
+		hash:   md5.New(),
+		format: pcmFormat{bytesPerSample: (int(bitsPerSample) + 7) / 8},
+	}
+}
+
+func (mh *md5Hasher) writeFrame(f *frame.Frame) {
+	mh.buffer = mh.format.interleave(mh.buffer, f)
+	mh.hash.Write(mh.buffer)
+}
+
+// compare computed signature with the stored one
+func (mh *md5Hasher) check(signature []byte) error {
+	if len(signature) == 0 || bytes.Equal(signature, make([]byte, md5.Size)) {
+		return ErrMD5NotSet
+	}
+	if !bytes.Equal(signature, mh.hash.Sum(nil)) {
+		return ErrMD5Mismatch
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package meta
 
 import (
+	"errors"
 	"github.com/icza/bitio"
 )
 
@@ -11,22 +12,31 @@ type Application struct {
 
 func readApplication(reader *bitio.Reader, size int) (*Application, error) {
 	application := &Application{}
+	if size < 4 {
+		return application, errors.New("incorrect APPLICATION size")
+	}
 
 	// 4 bytes per ID
-	id := make([]byte, 4)
-	_, err := reader.Read(id)
+	id, err := readBytes(reader, 4)
 	if err != nil {
 		return application, err
 	}
 	application.ID = string(id)
 
 	// all another data for application
-	data := make([]byte, size-4)
-	_, err = reader.Read(data)
+	application.Data, err = readBytes(reader, size-4)
 	if err != nil {
 		return application, err
 	}
-	application.Data = data
 
 	return application, nil
 }
+
+func writeApplication(writer *bitio.Writer, application *Application) error {
+	if len(application.ID) != 4 {
+		return errors.New("APPLICATION ID is not 4 bytes")
+	}
+	writer.TryWrite([]byte(application.ID))
+	writer.TryWrite(application.Data)
+	return writer.TryError
+}
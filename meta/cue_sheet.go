@@ -1,12 +1,15 @@
 package meta
 
-import "github.com/icza/bitio"
+import (
+	"errors"
+	"github.com/icza/bitio"
+)
 
 type CueSheet struct {
-	MediaCatalogNumber    string
+	MediaCatalogNumber    string // 128 bytes, padded with NUL characters
 	NumberOfLeadInSamples uint64
 	CompactDisc           bool
-	Reserved              []byte
+	Reserved              []byte // 7 bits in the first byte, then 258 bytes
 	NumberOfTracks        uint8
 	CueSheetTracks        []CueSheetTrack
 }
@@ -14,10 +17,10 @@ type CueSheet struct {
 type CueSheetTrack struct {
 	OffsetInSamples         uint64
 	TrackNumber             uint8
-	ISRC                    string
+	ISRC                    string // 12 bytes
 	NonAudioType            bool
 	PreEmphasis             bool
-	Reserved                []byte
+	Reserved                []byte // 6 bits in the first byte, then 13 bytes
 	NumberOfTrackIndexPoint uint8
 	CueSheetTrackIndexes    []CueSheetTrackIndex
 }
@@ -25,15 +28,14 @@ type CueSheetTrack struct {
 type CueSheetTrackIndex struct {
 	OffsetInSamples  uint64
 	IndexPointNumber uint8
-	Reserved         []byte
+	Reserved         []byte // 3 bytes
 }
 
 func readCueSheet(reader *bitio.Reader) (*CueSheet, error) {
 	cueSheet := &CueSheet{}
 
 	// media catalog number
-	mediaCatalogNumber := make([]byte, 128)
-	_, err := reader.Read(mediaCatalogNumber)
+	mediaCatalogNumber, err := readBytes(reader, 128)
 	if err != nil {
 		return cueSheet, err
 	}
@@ -52,8 +54,7 @@ func readCueSheet(reader *bitio.Reader) (*CueSheet, error) {
 	}
 
 	// reserved
-	cueSheet.Reserved = make([]byte, 7+258*8)
-	_, err = reader.Read(cueSheet.Reserved)
+	cueSheet.Reserved, err = readReserved(reader, 7, 258)
 	if err != nil {
 		return cueSheet, err
 	}
@@ -94,28 +95,26 @@ func readCueSheetTrack(reader *bitio.Reader) (*CueSheetTrack, error) {
 	cueSheetTrack.TrackNumber = uint8(trackNumber)
 
 	// ISRC
-	isrc := make([]byte, 12)
-	_, err = reader.Read(isrc)
+	isrc, err := readBytes(reader, 12)
 	if err != nil {
-		return cueSheetTrack, nil
+		return cueSheetTrack, err
 	}
 	cueSheetTrack.ISRC = string(isrc)
 
 	// non audio type
 	cueSheetTrack.NonAudioType, err = reader.ReadBool()
 	if err != nil {
-		return cueSheetTrack, nil
+		return cueSheetTrack, err
 	}
 
 	// Pre-emphasis
 	cueSheetTrack.PreEmphasis, err = reader.ReadBool()
 	if err != nil {
-		return cueSheetTrack, nil
+		return cueSheetTrack, err
 	}
 
 	// reserved
-	cueSheetTrack.Reserved = make([]byte, 6+13*8)
-	_, err = reader.Read(cueSheetTrack.Reserved)
+	cueSheetTrack.Reserved, err = readReserved(reader, 6, 13)
 	if err != nil {
 		return cueSheetTrack, err
 	}
@@ -156,11 +155,93 @@ func readCueSheetTrackIndex(reader *bitio.Reader) (*CueSheetTrackIndex, error) {
 	cueSheetTrackIndex.IndexPointNumber = uint8(indexPointNumber)
 
 	// reserved
-	cueSheetTrackIndex.Reserved = make([]byte, 3*8)
-	_, err = reader.Read(cueSheetTrackIndex.Reserved)
+	cueSheetTrackIndex.Reserved, err = readBytes(reader, 3)
 	if err != nil {
 		return cueSheetTrackIndex, err
 	}
 
 	return cueSheetTrackIndex, nil
 }
+
+// read reserved bits up to byte alignment into the first byte, then size bytes
+func readReserved(reader *bitio.Reader, bits uint8, size int) ([]byte, error) {
+	first, err := reader.ReadBits(bits)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readBytes(reader, size)
+	return append([]byte{byte(first)}, data...), err
+}
+
+// write reserved field of readReserved, zeros if the field is not set
+func writeReserved(writer *bitio.Writer, reserved []byte, bits uint8, size int) {
+	if len(reserved) != size+1 {
+		reserved = make([]byte, size+1)
+	}
+	writer.TryWriteBits(uint64(reserved[0]), bits)
+	writer.TryWrite(reserved[1:])
+}
+
+// write string of fixed size padded with NUL characters
+func writeFixedString(writer *bitio.Writer, value string, size int) error {
+	if len(value) > size {
+		return errors.New("string is too long")
+	}
+	data := make([]byte, size)
+	copy(data, value)
+	writer.TryWrite(data)
+	return writer.TryError
+}
+
+// number of tracks and index points are computed from the slices
+func writeCueSheet(writer *bitio.Writer, cueSheet *CueSheet) error {
+	if len(cueSheet.CueSheetTracks) > 0xFF {
+		return errors.New("too many CUESHEET tracks")
+	}
+
+	err := writeFixedString(writer, cueSheet.MediaCatalogNumber, 128)
+	if err != nil {
+		return err
+	}
+	writer.TryWriteBits(cueSheet.NumberOfLeadInSamples, 64)
+	writer.TryWriteBool(cueSheet.CompactDisc)
+	writeReserved(writer, cueSheet.Reserved, 7, 258)
+	writer.TryWriteBits(uint64(len(cueSheet.CueSheetTracks)), 8)
+	if writer.TryError != nil {
+		return writer.TryError
+	}
+
+	for i := range cueSheet.CueSheetTracks {
+		err = writeCueSheetTrack(writer, &cueSheet.CueSheetTracks[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCueSheetTrack(writer *bitio.Writer, cueSheetTrack *CueSheetTrack) error {
+	if len(cueSheetTrack.CueSheetTrackIndexes) > 0xFF {
+		return errors.New("too many CUESHEET track index points")
+	}
+
+	writer.TryWriteBits(cueSheetTrack.OffsetInSamples, 64)
+	writer.TryWriteBits(uint64(cueSheetTrack.TrackNumber), 8)
+	err := writeFixedString(writer, cueSheetTrack.ISRC, 12)
+	if err != nil {
+		return err
+	}
+	writer.TryWriteBool(cueSheetTrack.NonAudioType)
+	writer.TryWriteBool(cueSheetTrack.PreEmphasis)
+	writeReserved(writer, cueSheetTrack.Reserved, 6, 13)
+	writer.TryWriteBits(uint64(len(cueSheetTrack.CueSheetTrackIndexes)), 8)
+
+	for _, index := range cueSheetTrack.CueSheetTrackIndexes {
+		writer.TryWriteBits(index.OffsetInSamples, 64)
+		writer.TryWriteBits(uint64(index.IndexPointNumber), 8)
+		reserved := make([]byte, 3)
+		copy(reserved, index.Reserved)
+		writer.TryWrite(reserved)
+	}
+	return writer.TryError
+}
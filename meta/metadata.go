@@ -1,8 +1,11 @@
 package meta
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"github.com/icza/bitio"
+	"io"
 )
 
 type MetadataBlock struct {
@@ -13,35 +16,136 @@ type MetadataBlock struct {
 func ReadMetadataBlock(reader *bitio.Reader) (*MetadataBlock, error) {
 	metadata := &MetadataBlock{}
 
-	header, err := readMetadataBlockHeader(reader)
+	header, err := ReadMetadataBlockHeader(reader)
 	if err != nil {
 		return metadata, err
 	}
 	metadata.Header = *header
+	metadata.Data, err = ReadMetadataBlockData(reader, header)
+	return metadata, err
+}
 
-	switch metadata.Header.Type {
+// read data of metadata block after its header
+func ReadMetadataBlockData(reader *bitio.Reader, header *MetadataBlockHeader) (MetadataBlockData, error) {
+	var data MetadataBlockData
+	var err error
+	switch header.Type {
 	case StreamInfoBlockType:
-		metadata.Data, err = readStreamInfo(reader)
+		data, err = readStreamInfo(reader)
 	case PaddingBlockType:
-		metadata.Data, err = readPadding(reader, header.Length)
+		data, err = readPadding(reader, header.Length)
 	case ApplicationBlockType:
-		metadata.Data, err = readApplication(reader, header.Length)
+		data, err = readApplication(reader, header.Length)
 	case SeekTableBlockType:
-		metadata.Data, err = readSeekTable(reader, header.Length)
+		data, err = readSeekTable(reader, header.Length)
 	case VorbisCommentBlockType:
-		metadata.Data, err = readVorbisComment(reader)
+		data, err = readVorbisComment(reader)
 	case CueSheetBlockType:
-		metadata.Data, err = readCueSheet(reader)
+		data, err = readCueSheet(reader)
 	case PictureBlockType:
-		metadata.Data, err = readPicture(reader)
+		data, err = readPicture(reader)
 	case InvalidBlockType:
 		err = errors.New("invalid block type")
 	default:
-		data := make([]byte, header.Length)
-		_, err = reader.Read(data)
+		data, err = readUnknown(reader, header.Length)
 	}
 
-	return metadata, err
+	return data, err
+}
+
+// read exactly size bytes, Read of bitio.Reader may return less
+func readBytes(reader *bitio.Reader, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(reader, data)
+	return data, err
+}
+
+// write metadata block with its header
+// the header length is computed from the data
+func WriteMetadataBlock(writer *bitio.Writer, block *MetadataBlock) error {
+	blockType := blockTypeOf(block.Data)
+	if blockType != InvalidBlockType && blockType != block.Header.Type {
+		return fmt.Errorf("%s metadata block has %s data", block.Header.Type.String(), blockType.String())
+	}
+
+	var data bytes.Buffer
+	dataWriter := bitio.NewWriter(&data)
+
+	var err error
+	switch blockData := block.Data.(type) {
+	case *StreamInfo:
+		err = writeStreamInfo(dataWriter, blockData)
+	case *Padding:
+		err = writePadding(dataWriter, blockData)
+	case *Application:
+		err = writeApplication(dataWriter, blockData)
+	case *SeekTable:
+		err = writeSeekTable(dataWriter, blockData)
+	case *VorbisComment:
+		err = writeVorbisComment(dataWriter, blockData)
+	case *CueSheet:
+		err = writeCueSheet(dataWriter, blockData)
+	case *Picture:
+		err = writePicture(dataWriter, blockData)
+	case *Unknown:
+		err = writeUnknown(dataWriter, blockData)
+	default:
+		err = fmt.Errorf("unknown data of %s metadata block", block.Header.Type.String())
+	}
+	if err != nil {
+		return err
+	}
+	err = dataWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	header := block.Header
+	header.Length = data.Len()
+	err = WriteMetadataBlockHeader(writer, &header)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data.Bytes())
+	return err
+}
+
+// write metadata block with its header to writer, implements io.WriterTo
+// the header length is computed from the data
+func (mb *MetadataBlock) WriteTo(writer io.Writer) (int64, error) {
+	var buffer bytes.Buffer
+	bits := bitio.NewWriter(&buffer)
+	err := WriteMetadataBlock(bits, mb)
+	if err != nil {
+		return 0, err
+	}
+	err = bits.Close()
+	if err != nil {
+		return 0, err
+	}
+	n, err := writer.Write(buffer.Bytes())
+	return int64(n), err
+}
+
+// return block type of metadata block data, InvalidBlockType for Unknown data
+func blockTypeOf(data MetadataBlockData) BlockType {
+	switch data.(type) {
+	case *StreamInfo:
+		return StreamInfoBlockType
+	case *Padding:
+		return PaddingBlockType
+	case *Application:
+		return ApplicationBlockType
+	case *SeekTable:
+		return SeekTableBlockType
+	case *VorbisComment:
+		return VorbisCommentBlockType
+	case *CueSheet:
+		return CueSheetBlockType
+	case *Picture:
+		return PictureBlockType
+	}
+	return InvalidBlockType
 }
 
 type MetadataBlockHeader struct {
@@ -52,7 +156,7 @@ type MetadataBlockHeader struct {
 
 type MetadataBlockData interface{}
 
-func readMetadataBlockHeader(reader *bitio.Reader) (*MetadataBlockHeader, error) {
+func ReadMetadataBlockHeader(reader *bitio.Reader) (*MetadataBlockHeader, error) {
 	header := MetadataBlockHeader{}
 
 	// IsLast: 1 bit
@@ -78,3 +182,13 @@ func readMetadataBlockHeader(reader *bitio.Reader) (*MetadataBlockHeader, error)
 
 	return &header, nil
 }
+
+func WriteMetadataBlockHeader(writer *bitio.Writer, header *MetadataBlockHeader) error {
+	if header.Length >= 1<<24 {
+		return errors.New("metadata block is too long")
+	}
+	writer.TryWriteBool(header.IsLast)
+	writer.TryWriteBits(uint64(header.Type), 7)
+	writer.TryWriteBits(uint64(header.Length), 24)
+	return writer.TryError
+}
@@ -9,9 +9,11 @@ type Padding struct {
 }
 
 func readPadding(reader *bitio.Reader, size int) (*Padding, error) {
-	padding := &Padding{
-		Data: make([]byte, size),
-	}
-	_, err := reader.Read(padding.Data)
-	return padding, err
+	data, err := readBytes(reader, size)
+	return &Padding{Data: data}, err
+}
+
+func writePadding(writer *bitio.Writer, padding *Padding) error {
+	writer.TryWrite(padding.Data)
+	return writer.TryError
 }
@@ -20,62 +20,101 @@ type Picture struct {
 	BitsPerPixel   int32
 	NumberOfColors int32
 	PictureData    []byte
+	DataSkipped    bool // PictureData is not read by ReadPictureWithoutData, so the picture can not be written
 }
 
 func readPicture(reader *bitio.Reader) (*Picture, error) {
-	var picture Picture
+	picture, length, err := ReadPictureWithoutData(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Picture data
+	picture.PictureData, err = readBytes(reader, int(length))
+	if err != nil {
+		return nil, err
+	}
+	picture.DataSkipped = false
+
+	return picture, nil
+}
+
+// read PICTURE data up to the length of the picture data, returns the picture without PictureData
+// and the length of the picture data which follows, so the caller can skip it
+func ReadPictureWithoutData(reader *bitio.Reader) (*Picture, uint32, error) {
+	picture := Picture{DataSkipped: true}
 
 	// Picture type
 	err := binary.Read(reader, binary.BigEndian, &picture.Type)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// MIME
 	MIMEBytes, err := readLengthData(reader, binary.BigEndian)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	picture.MIME = string(MIMEBytes)
 
 	// Description
 	DescriptionBytes, err := readLengthData(reader, binary.BigEndian)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	picture.Description = string(DescriptionBytes)
 
-	// Width
-	err = binary.Read(reader, binary.BigEndian, &picture.Width)
-	if err != nil {
-		return nil, err
+	// Width, height, bits per pixel, number of colors
+	for _, value := range []*int32{&picture.Width, &picture.Height, &picture.BitsPerPixel, &picture.NumberOfColors} {
+		err = binary.Read(reader, binary.BigEndian, value)
+		if err != nil {
+			return nil, 0, err
+		}
 	}
 
-	// Height
-	err = binary.Read(reader, binary.BigEndian, &picture.Height)
+	// length of picture data
+	var length uint32
+	err = binary.Read(reader, binary.BigEndian, &length)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	// Bits per pixel
-	err = binary.Read(reader, binary.BigEndian, &picture.BitsPerPixel)
+	return &picture, length, nil
+}
+
+func writePicture(writer *bitio.Writer, picture *Picture) error {
+	if picture.DataSkipped {
+		return errors.New("PICTURE data is not read")
+	}
+
+	// Picture type
+	err := binary.Write(writer, binary.BigEndian, picture.Type)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Number of colors
-	err = binary.Read(reader, binary.BigEndian, &picture.NumberOfColors)
+	// MIME
+	err = writeLengthData(writer, binary.BigEndian, []byte(picture.MIME))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Picture data
-	picture.PictureData, err = readLengthData(reader, binary.BigEndian)
+	// Description
+	err = writeLengthData(writer, binary.BigEndian, []byte(picture.Description))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &picture, nil
+	// Width, height, bits per pixel, number of colors
+	for _, value := range []int32{picture.Width, picture.Height, picture.BitsPerPixel, picture.NumberOfColors} {
+		err = binary.Write(writer, binary.BigEndian, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Picture data
+	return writeLengthData(writer, binary.BigEndian, picture.PictureData)
 }
 
 func (p *Picture) GetImage() (image.Image, error) {
@@ -115,6 +154,17 @@ func readLengthData(reader *bitio.Reader, order binary.ByteOrder) ([]byte, error
 	return data, nil
 }
 
+// Write format:
+// [length, data]
+func writeLengthData(writer *bitio.Writer, order binary.ByteOrder, data []byte) error {
+	err := binary.Write(writer, order, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
 func downloadImage(url string) (image.Image, error) {
 	resp, err := http.Get(url)
 	if err != nil {
@@ -10,9 +10,16 @@ type SeekTable struct {
 }
 
 type SeekPoint struct {
-	SampleNumberOfFirstSample uint64
-	Offset                    uint64
-	NumberOfSamples           uint16
+	SampleNumberOfFirstSample uint64 // Sample number of first sample in the target frame, or 0xFFFFFFFFFFFFFFFF for a placeholder point.
+	Offset                    uint64 // Offset (in bytes) from the first byte of the first frame header to the first byte of the target frame's header.
+	NumberOfSamples           uint16 // Number of samples in the target frame.
+}
+
+// sample number of placeholder seek point
+const PlaceholderSampleNumber uint64 = 0xFFFFFFFFFFFFFFFF
+
+func (sp *SeekPoint) IsPlaceholder() bool {
+	return sp.SampleNumberOfFirstSample == PlaceholderSampleNumber
 }
 
 func readSeekTable(reader *bitio.Reader, size int) (*SeekTable, error) {
@@ -60,3 +67,12 @@ func readSeekPoint(reader *bitio.Reader) (*SeekPoint, error) {
 
 	return seekPoint, nil
 }
+
+func writeSeekTable(writer *bitio.Writer, seekTable *SeekTable) error {
+	for _, seekPoint := range seekTable.SeekPoints {
+		writer.TryWriteBits(seekPoint.SampleNumberOfFirstSample, 64)
+		writer.TryWriteBits(seekPoint.Offset, 64)
+		writer.TryWriteBits(uint64(seekPoint.NumberOfSamples), 16)
+	}
+	return writer.TryError
+}
@@ -17,7 +17,7 @@ type StreamInfo struct {
 	SampleRate           uint32 // Sample rate in Hz. Though 20 bits are available, the maximum sample rate is limited by the structure of frame headers to 655350Hz. Also, a value of 0 is invalid.
 	NumberOfChannels     uint8  // (number of channels)-1. FLAC supports from 1 to 8 channels
 	BitsPerSample        uint8  // (bits per sample)-1. FLAC supports from 4 to 32 bits per sample. Currently the reference encoder and decoders only support up to 24 bits per sample.
-	TotalSamplesInStream uint32 // Total samples in stream. 'Samples' means inter-channel sample, i.e. one second of 44.1Khz audio will have 44100 samples regardless of the number of channels. A value of zero here means the number of total samples is unknown.
+	TotalSamplesInStream uint64 // Total samples in stream. 'Samples' means inter-channel sample, i.e. one second of 44.1Khz audio will have 44100 samples regardless of the number of channels. A value of zero here means the number of total samples is unknown.
 	MD5                  []byte // MD5 signature of the unencoded audio data. This allows the decoder to determine if an error exists in the audio data even when the error does not result in an invalid bitstream.
 }
 
@@ -95,14 +95,36 @@ func readStreamInfo(reader *bitio.Reader) (*StreamInfo, error) {
 	if err != nil {
 		return si, err
 	}
-	si.TotalSamplesInStream = uint32(totalSamplesInStream)
+	si.TotalSamplesInStream = totalSamplesInStream
 
 	// 128 bits (16 bytes) per MD5 signature
-	si.MD5 = make([]byte, 16)
-	_, err = reader.Read(si.MD5)
+	si.MD5, err = readBytes(reader, 16)
 	if err != nil {
 		return si, err
 	}
 
 	return si, si.check()
 }
+
+func writeStreamInfo(writer *bitio.Writer, si *StreamInfo) error {
+	err := si.check()
+	if err != nil {
+		return err
+	}
+
+	writer.TryWriteBits(uint64(si.MinimumBlockSize), 16)
+	writer.TryWriteBits(uint64(si.MaximumBlockSize), 16)
+	writer.TryWriteBits(uint64(si.MinimumFrameSize), 24)
+	writer.TryWriteBits(uint64(si.MaximumFrameSize), 24)
+	writer.TryWriteBits(uint64(si.SampleRate), 20)
+	writer.TryWriteBits(uint64(si.NumberOfChannels-1), 3)
+	writer.TryWriteBits(uint64(si.BitsPerSample-1), 5)
+	writer.TryWriteBits(si.TotalSamplesInStream, 36)
+
+	// MD5 signature, zeros if not known
+	md5 := make([]byte, 16)
+	copy(md5, si.MD5)
+	writer.TryWrite(md5)
+
+	return writer.TryError
+}
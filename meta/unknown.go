@@ -0,0 +1,20 @@
+package meta
+
+import (
+	"github.com/icza/bitio"
+)
+
+// data of metadata block of reserved type, kept to write the block back
+type Unknown struct {
+	Data []byte
+}
+
+func readUnknown(reader *bitio.Reader, size int) (*Unknown, error) {
+	data, err := readBytes(reader, size)
+	return &Unknown{Data: data}, err
+}
+
+func writeUnknown(writer *bitio.Writer, unknown *Unknown) error {
+	writer.TryWrite(unknown.Data)
+	return writer.TryError
+}
@@ -41,8 +41,7 @@ func readVorbisComment(reader *bitio.Reader) (*VorbisComment, error) {
 		return vorbisComment, err
 	}
 
-	vendorString := make([]byte, vorbisComment.VendorLength)
-	_, err = reader.Read(vendorString)
+	vendorString, err := readBytes(reader, int(vorbisComment.VendorLength))
 	if err != nil {
 		return vorbisComment, err
 	}
@@ -72,8 +71,7 @@ func readUserComment(reader *bitio.Reader) (*UserComment, error) {
 		return userComment, err
 	}
 
-	userString := make([]byte, userComment.Length)
-	_, err = reader.Read(userString)
+	userString, err := readBytes(reader, int(userComment.Length))
 	if err != nil {
 		return userComment, err
 	}
@@ -87,3 +85,25 @@ func readUserComment(reader *bitio.Reader) (*UserComment, error) {
 	userComment.Value = comment[1]
 	return userComment, nil
 }
+
+// write comment header without the framing bit, like readVorbisComment
+// lengths are computed from the strings, VendorLength and Length fields are not used
+func writeVorbisComment(writer *bitio.Writer, vorbisComment *VorbisComment) error {
+	err := writeLengthData(writer, binary.LittleEndian, []byte(vorbisComment.VendorString))
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(writer, binary.LittleEndian, uint32(len(vorbisComment.UserComments)))
+	if err != nil {
+		return err
+	}
+
+	for _, userComment := range vorbisComment.UserComments {
+		err = writeLengthData(writer, binary.LittleEndian, []byte(userComment.Key+"="+userComment.Value))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package flac
+
+import (
+	"errors"
+	"fmt"
+	"frolovo22/flac/meta"
+	"io/ioutil"
+)
+
+// append metadata block, see InsertMetadataBlock
+func (f *FLAC) AddMetadataBlock(block meta.MetadataBlock) error {
+	return f.InsertMetadataBlock(len(f.MetadataBlocks), block)
+}
+
+// insert metadata block before block i
+// the blocks must stay valid: STREAMINFO is the first block and appears once, SEEKTABLE and VORBIS_COMMENT at most once
+// IsLast of the block headers and Length of the inserted block are recomputed,
+// use UpdateMetadataHeaders after the data of other blocks is edited directly
+func (f *FLAC) InsertMetadataBlock(i int, block meta.MetadataBlock) error {
+	if i < 0 || i > len(f.MetadataBlocks) {
+		return errors.New("metadata block index is out of range")
+	}
+	blocks := make([]meta.MetadataBlock, 0, len(f.MetadataBlocks)+1)
+	blocks = append(blocks, f.MetadataBlocks[:i]...)
+	blocks = append(blocks, block)
+	blocks = append(blocks, f.MetadataBlocks[i:]...)
+	return f.setMetadataBlocks(blocks, i)
+}
+
+// replace the first metadata block of the same type, the block is added if there is no such block
+func (f *FLAC) ReplaceMetadataBlock(block meta.MetadataBlock) error {
+	i := f.MetadataBlockIndex(block.Header.Type)
+	if i < 0 {
+		return f.AddMetadataBlock(block)
+	}
+	blocks := append([]meta.MetadataBlock{}, f.MetadataBlocks...)
+	blocks[i] = block
+	return f.setMetadataBlocks(blocks, i)
+}
+
+// remove all metadata blocks of the type, returns the number of removed blocks
+// STREAMINFO can not be removed
+func (f *FLAC) RemoveMetadataBlocks(blockType meta.BlockType) (int, error) {
+	var blocks []meta.MetadataBlock
+	for _, block := range f.MetadataBlocks {
+		if block.Header.Type != blockType {
+			blocks = append(blocks, block)
+		}
+	}
+	removed := len(f.MetadataBlocks) - len(blocks)
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, f.setMetadataBlocks(blocks, -1)
+}
+
+// move metadata block from index from to index to, the blocks in between are shifted, see MoveMetadataBlocks to move blocks by type
+func (f *FLAC) MoveMetadataBlock(from int, to int) error {
+	if from < 0 || from >= len(f.MetadataBlocks) || to < 0 || to >= len(f.MetadataBlocks) {
+		return errors.New("metadata block index is out of range")
+	}
+	blocks := append([]meta.MetadataBlock{}, f.MetadataBlocks...)
+	block := blocks[from]
+	if from < to {
+		copy(blocks[from:to], blocks[from+1:to+1])
+	} else {
+		copy(blocks[to+1:from+1], blocks[to:from])
+	}
+	blocks[to] = block
+	return f.setMetadataBlocks(blocks, -1)
+}
+
+// move all metadata blocks of the type before block to, keeping their order;
+// to is the index in the current blocks, len(f.MetadataBlocks) moves them to the end
+func (f *FLAC) MoveMetadataBlocks(blockType meta.BlockType, to int) error {
+	if to < 0 || to > len(f.MetadataBlocks) {
+		return errors.New("metadata block index is out of range")
+	}
+	var moved, blocks []meta.MetadataBlock
+	position := -1
+	for i, block := range f.MetadataBlocks {
+		if i == to {
+			position = len(blocks)
+		}
+		if block.Header.Type == blockType {
+			moved = append(moved, block)
+		} else {
+			blocks = append(blocks, block)
+		}
+	}
+	if len(moved) == 0 {
+		return errors.New("metadata block not found")
+	}
+	if position < 0 {
+		position = len(blocks)
+	}
+	blocks = append(blocks[:position], append(moved, blocks[position:]...)...)
+	return f.setMetadataBlocks(blocks, -1)
+}
+
+// return index of the first metadata block of the type, -1 if not found
+func (f *FLAC) MetadataBlockIndex(blockType meta.BlockType) int {
+	for i, block := range f.MetadataBlocks {
+		if block.Header.Type == blockType {
+			return i
+		}
+	}
+	return -1
+}
+
+// recompute IsLast and Length of the metadata block headers after the blocks are edited directly
+func (f *FLAC) UpdateMetadataHeaders() error {
+	return updateMetadataHeaders(f.MetadataBlocks)
+}
+
+// check and set the blocks, the blocks are not changed on error
+// IsLast is recomputed for every block, Length only for block changed, -1 if no block is changed
+func (f *FLAC) setMetadataBlocks(blocks []meta.MetadataBlock, changed int) error {
+	err := checkMetadataBlocks(blocks)
+	if err != nil {
+		return err
+	}
+	if changed >= 0 {
+		err = updateMetadataLength(&blocks[changed])
+		if err != nil {
+			return err
+		}
+	}
+	updateLastMetadataBlock(blocks)
+	f.MetadataBlocks = blocks
+	return nil
+}
+
+// check the order and the number of metadata blocks
+func checkMetadataBlocks(blocks []meta.MetadataBlock) error {
+	err := checkFirstMetadataBlock(blocks)
+	if err != nil {
+		return err
+	}
+	count := make(map[meta.BlockType]int)
+	for _, block := range blocks {
+		count[block.Header.Type]++
+	}
+	for _, blockType := range []meta.BlockType{meta.StreamInfoBlockType, meta.SeekTableBlockType, meta.VorbisCommentBlockType} {
+		if count[blockType] > 1 {
+			return fmt.Errorf("more than one %s metadata block", blockType.String())
+		}
+	}
+	return nil
+}
+
+func checkFirstMetadataBlock(blocks []meta.MetadataBlock) error {
+	if len(blocks) == 0 || blocks[0].Header.Type != meta.StreamInfoBlockType {
+		return errors.New("first metadata block is not STREAMINFO")
+	}
+	return nil
+}
+
+// set IsLast of the last block only and Length from the block data
+func updateMetadataHeaders(blocks []meta.MetadataBlock) error {
+	for i := range blocks {
+		err := updateMetadataLength(&blocks[i])
+		if err != nil {
+			return err
+		}
+	}
+	updateLastMetadataBlock(blocks)
+	return nil
+}
+
+func updateLastMetadataBlock(blocks []meta.MetadataBlock) {
+	for i := range blocks {
+		blocks[i].Header.IsLast = i == len(blocks)-1
+	}
+}
+
+// set Length from the block data, Length of PICTURE read without data is kept
+func updateMetadataLength(block *meta.MetadataBlock) error {
+	if picture, ok := block.Data.(*meta.Picture); ok && picture.DataSkipped {
+		return nil
+	}
+	n, err := block.WriteTo(ioutil.Discard)
+	if err != nil {
+		return err
+	}
+	block.Header.Length = int(n) - 4
+	return nil
+}
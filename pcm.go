@@ -0,0 +1,105 @@
+package flac
+
+import (
+	"errors"
+	"frolovo22/flac/frame"
+)
+
+// pcmFormat describes byte layout of interleaved PCM samples
+type pcmFormat struct {
+	bytesPerSample int
+	bigEndian      bool
+	unsigned       bool // only for 1 byte samples
+	shift          uint // left shift of samples which are narrower than the sample width
+}
+
+// write samples of frame interleaved by channel into buffer, the buffer grows if needed
+func (pf *pcmFormat) interleave(buffer []byte, f *frame.Frame) []byte {
+	if len(f.Subframes) == 0 {
+		return buffer[:0]
+	}
+	blockSize := len(f.Subframes[0].Samples)
+	size := blockSize * len(f.Subframes) * pf.bytesPerSample
+	if cap(buffer) < size {
+		buffer = make([]byte, size)
+	}
+	buffer = buffer[:size]
+
+	i := 0
+	for sample := 0; sample < blockSize; sample++ {
+		for _, subframe := range f.Subframes {
+			value := subframe.Samples[sample] << pf.shift
+			if pf.unsigned && pf.bytesPerSample == 1 {
+				value += 128
+			}
+			for b := 0; b < pf.bytesPerSample; b++ {
+				shift := 8 * b
+				if pf.bigEndian {
+					shift = 8 * (pf.bytesPerSample - 1 - b)
+				}
+				buffer[i] = byte(value >> uint(shift))
+				i++
+			}
+		}
+	}
+	return buffer
+}
+
+// PCMReader reads decoded audio as interleaved PCM bytes, it implements io.Reader.
+// By default samples are signed little-endian, sample width is the stream bits per sample rounded up to whole bytes
+// (8, 16, 24 or 32 bit) and the samples are left-justified in it like in WAV, e.g. 12 bit samples are shifted by 4 bits;
+// other layouts are selected by the fields before the first Read.
+type PCMReader struct {
+	BigEndian bool // big-endian samples instead of little-endian
+	Unsigned8 bool // 8 bit samples are unsigned (offset by 128)
+	Pad24     bool // 24 bit samples are sign-extended to 32 bit (24-in-32)
+
+	decoder *Decoder
+	buffer  []byte
+	pending []byte // decoded bytes which are not read yet
+}
+
+func NewPCMReader(decoder *Decoder) *PCMReader {
+	return &PCMReader{decoder: decoder}
+}
+
+func (r *PCMReader) format() (*pcmFormat, error) {
+	streamInfo := r.decoder.StreamInfo()
+	if streamInfo == nil {
+		return nil, errors.New("STREAMINFO metadata block not found")
+	}
+	format := &pcmFormat{
+		bytesPerSample: (int(streamInfo.BitsPerSample) + 7) / 8,
+		bigEndian:      r.BigEndian,
+		unsigned:       r.Unsigned8,
+	}
+	format.shift = uint(8*format.bytesPerSample - int(streamInfo.BitsPerSample))
+	if r.Pad24 && format.bytesPerSample == 3 {
+		format.bytesPerSample = 4
+	}
+	return format, nil
+}
+
+// read decoded bytes, a frame is decoded only when no decoded bytes are left and p is not empty
+func (r *PCMReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(r.pending) == 0 {
+		// the format depends only on STREAMINFO, so no frame is lost if it fails
+		format, err := r.format()
+		if err != nil {
+			return 0, err
+		}
+		f, err := r.decoder.NextFrame()
+		if err != nil {
+			return 0, err
+		}
+		r.buffer = format.interleave(r.buffer, f)
+		r.pending = r.buffer
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,30 @@
+package flac
+
+import (
+	"bufio"
+	"io"
+)
+
+// countReader counts bytes read from the stream
+type countReader struct {
+	reader *bufio.Reader
+	offset int64
+}
+
+func newCountReader(reader io.Reader) *countReader {
+	return &countReader{reader: bufio.NewReader(reader)}
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.offset += int64(n)
+	return n, err
+}
+
+func (cr *countReader) ReadByte() (byte, error) {
+	b, err := cr.reader.ReadByte()
+	if err == nil {
+		cr.offset++
+	}
+	return b, err
+}
@@ -0,0 +1,184 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"frolovo22/flac/frame"
+	"frolovo22/flac/meta"
+	"github.com/icza/bitio"
+	"io"
+)
+
+// distance in bytes, below which the bisection search stops and frames are decoded one by one
+const seekLinearDistance = 64 * 1024
+
+// maximum size of frame header in bytes
+const maxFrameHeaderSize = 16
+
+// move the decoder so that the frame returned by the next NextFrame call starts exactly at sample n
+// the reader of the decoder must implement io.Seeker
+// the nearest SEEKTABLE point is used if present, the rest is found by bisection search of
+// frame sync codes validated by CRC-8
+// the next frame keeps the header of the decoded frame, so its block size and sample number are of the whole frame,
+// while its Subframes hold only the samples from n
+// MD5 verification is disabled after seeking, EnableMD5 and CheckMD5 return ErrMD5Seeked
+func (d *Decoder) SeekSample(n uint64) error {
+	seeker, ok := d.source.(io.Seeker)
+	if !ok {
+		return errors.New("reader does not implement io.Seeker")
+	}
+
+	// SEEKTABLE may be in metadata which is not read yet
+	_, err := d.ReadMetadataBlocks()
+	if err != nil {
+		return err
+	}
+	streamInfo := d.StreamInfo()
+	if streamInfo.TotalSamplesInStream > 0 && n >= streamInfo.TotalSamplesInStream {
+		return errors.New("sample number is out of range")
+	}
+	d.md5 = nil
+	d.seeked = true
+
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	end -= d.start
+
+	// range of byte offsets where the target frame starts, low is always a frame start
+	low, high := d.audioOffset, end
+	if seekTable := d.flac.SeekTable(); seekTable != nil {
+		for _, point := range seekTable.SeekPoints {
+			if point.IsPlaceholder() {
+				continue
+			}
+			offset := d.audioOffset + int64(point.Offset)
+			if point.SampleNumberOfFirstSample <= n && offset > low {
+				low = offset
+			}
+			if point.SampleNumberOfFirstSample > n && offset < high {
+				high = offset
+			}
+		}
+	}
+
+	// bisection search
+	for high-low > seekLinearDistance {
+		middle := low + (high-low)/2
+		offset, header, err := d.findFrame(middle, high)
+		if err != nil {
+			return err
+		}
+		if header != nil && header.GetSampleNumber(streamInfo) <= n {
+			low = offset
+		} else {
+			// the target frame starts before the first frame found after middle
+			high = middle
+		}
+	}
+
+	// decode frames up to the target one
+	err = d.reset(low)
+	if err != nil {
+		return err
+	}
+	for {
+		f, err := frame.ReadFrame(d.reader, streamInfo)
+		if err == io.EOF {
+			return errors.New("sample number is out of range")
+		}
+		if err != nil {
+			return err
+		}
+
+		first := f.Header.GetSampleNumber(streamInfo)
+		blockSize := uint64(f.Header.GetBlockSize())
+		if first > n {
+			return errors.New("frame with the sample not found")
+		}
+		if first+blockSize <= n {
+			continue
+		}
+
+		// discard samples before n
+		for i := range f.Subframes {
+			f.Subframes[i].Samples = f.Subframes[i].Samples[n-first:]
+		}
+		d.pending = f
+		return nil
+	}
+}
+
+// restart reading frames at byte offset from the stream marker
+func (d *Decoder) reset(offset int64) error {
+	_, err := d.source.(io.Seeker).Seek(d.start+offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	d.counter = newCountReader(d.source)
+	d.counter.offset = offset
+	d.reader = bitio.NewReader(d.counter)
+	d.pending = nil
+	return nil
+}
+
+// find the first frame which starts in [from, to), offsets are from the stream marker
+// frame header is validated by sync code, CRC-8 and STREAMINFO
+// returns nil header if not found
+func (d *Decoder) findFrame(from int64, to int64) (int64, *frame.FrameHeader, error) {
+	_, err := d.source.(io.Seeker).Seek(d.start+from, io.SeekStart)
+	if err != nil {
+		return 0, nil, err
+	}
+	reader := bufio.NewReader(d.source)
+
+	for offset := from; offset < to; offset++ {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			return 0, nil, nil
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if b != 0xFF {
+			continue
+		}
+
+		// sync code 1111 1111 1111 10, reserved bit, blocking strategy bit
+		next, _ := reader.Peek(maxFrameHeaderSize - 1)
+		if len(next) == 0 || next[0]&0xFE != 0xF8 {
+			continue
+		}
+		header, err := frame.ReadFrameHeader(bitio.NewReader(bytes.NewReader(append([]byte{b}, next...))))
+		if err != nil || !isStreamFrameHeader(header, d.StreamInfo()) {
+			continue
+		}
+		return offset, header, nil
+	}
+	return 0, nil, nil
+}
+
+// check that frame header agrees with STREAMINFO
+func isStreamFrameHeader(header *frame.FrameHeader, streamInfo *meta.StreamInfo) bool {
+	if header.ChannelAssigment.NumberOfChannels() != int(streamInfo.NumberOfChannels) {
+		return false
+	}
+	if header.GetBitsPerSample(streamInfo) != streamInfo.BitsPerSample {
+		return false
+	}
+	if header.GetSampleRate(streamInfo) != streamInfo.SampleRate {
+		return false
+	}
+	if streamInfo.MaximumBlockSize > 0 && header.GetBlockSize() > uint32(streamInfo.MaximumBlockSize) {
+		return false
+	}
+	return true
+}
+
+// move the reader so that the next Read starts exactly at sample n, see Decoder.SeekSample
+func (r *PCMReader) SeekSample(n uint64) error {
+	r.pending = nil
+	return r.decoder.SeekSample(n)
+}
@@ -0,0 +1,85 @@
+package flac
+
+import (
+	"errors"
+	"frolovo22/flac/meta"
+)
+
+// metadata block length is 24 bits, each seek point is 18 bytes
+const maxSeekPoints = (1<<24 - 1) / 18
+
+// return SEEKTABLE of placeholder points reserved by the options, nil if there are no seek points
+func (eo *EncoderOptions) seekTable() (*meta.SeekTable, error) {
+	points := eo.SeekPoints
+	if points < 0 {
+		return nil, errors.New("incorrect number of seek points")
+	}
+	if points == 0 && eo.SeekPointInterval > 0 {
+		// the number of points can not be known before the stream is written
+		if eo.TotalSamples == 0 {
+			return nil, errors.New("seek point interval requires number of seek points or total samples")
+		}
+		count := (eo.TotalSamples-1)/eo.SeekPointInterval + 1
+		if count > maxSeekPoints {
+			return nil, errors.New("too many seek points")
+		}
+		points = int(count)
+	}
+	if points == 0 {
+		return nil, nil
+	}
+	if eo.SeekPointInterval == 0 && eo.TotalSamples == 0 {
+		return nil, errors.New("seek points require seek point interval or total samples")
+	}
+	if points > maxSeekPoints {
+		return nil, errors.New("too many seek points")
+	}
+
+	seekTable := &meta.SeekTable{SeekPoints: make([]meta.SeekPoint, points)}
+	for i := range seekTable.SeekPoints {
+		seekTable.SeekPoints[i].SampleNumberOfFirstSample = meta.PlaceholderSampleNumber
+	}
+	return seekTable, nil
+}
+
+// return number of samples between seek points
+func (eo *EncoderOptions) seekPointInterval(points int) uint64 {
+	if eo.SeekPointInterval > 0 {
+		return eo.SeekPointInterval
+	}
+	interval := (eo.TotalSamples + uint64(points) - 1) / uint64(points)
+	if interval == 0 {
+		interval = 1
+	}
+	return interval
+}
+
+// fill the next placeholder point if the frame just written holds the next seek point target
+// offset is the frame offset from the first frame, like in SEEKTABLE
+func (e *Encoder) addSeekPoint(offset uint64, blockSize uint16) {
+	if e.seekTable == nil {
+		return
+	}
+	end := e.sampleNumber + uint64(blockSize)
+	if e.nextSeekPoint >= end {
+		return
+	}
+
+	// the first unused point, points are filled in order
+	for i := range e.seekTable.SeekPoints {
+		if e.seekTable.SeekPoints[i].IsPlaceholder() {
+			e.seekTable.SeekPoints[i] = meta.SeekPoint{
+				SampleNumberOfFirstSample: e.sampleNumber,
+				Offset:                    offset,
+				NumberOfSamples:           blockSize,
+			}
+			break
+		}
+	}
+
+	// targets inside the frame point to the same frame
+	interval := e.options.seekPointInterval(len(e.seekTable.SeekPoints))
+	for e.nextSeekPoint < end {
+		e.nextSeekPoint += interval
+	}
+}
@@ -0,0 +1,83 @@
+package flac
+
+import "frolovo22/flac/frame"
+
+// StereoMode selects inter-channel decorrelation of 2-channel streams
+type StereoMode uint8
+
+const (
+	// channels are coded independently
+	IndependentStereo StereoMode = 0
+
+	// independent, left/side, right/side and mid/side coding are encoded and the smallest is kept, like -m of the reference encoder
+	ExhaustiveStereo StereoMode = 1
+
+	// coding with the smallest estimated size is encoded, like -M of the reference encoder
+	AdaptiveStereo StereoMode = 2
+)
+
+// channel assigments to choose from and indexes of their coded channels in [left, right, mid, side]
+var stereoAssigments = []struct {
+	assigment frame.ChannelAssigment
+	channels  [2]int
+}{
+	{frame.ChannelAssigment(1), [2]int{0, 1}},
+	{frame.LeftSideStereo, [2]int{0, 3}},
+	{frame.RightSideStereo, [2]int{3, 1}},
+	{frame.MidSideStereo, [2]int{2, 3}},
+}
+
+// encode subframes of the channels, for 2 channels the channel assigment is chosen by the stereo mode
+// subframes hold samples of the channels, as frame.WriteFrame expects
+// returns encoded size of the subframes in bits
+func (e *Encoder) encodeSubframes(channels [][]int32) (frame.ChannelAssigment, []frame.Subframe, uint64) {
+	bitsPerSample := e.streamInfo.BitsPerSample
+	// side channel of 32 bit samples does not fit int32
+	if len(channels) != 2 || e.options.StereoMode == IndependentStereo || bitsPerSample >= 32 {
+		subframes := make([]frame.Subframe, len(channels))
+		var size uint64
+		for i, channel := range channels {
+			var subframeSize uint64
+			subframes[i], subframeSize = frame.EncodeSubframe(channel, bitsPerSample, e.subframe)
+			size += subframeSize
+		}
+		return frame.ChannelAssigment(len(channels) - 1), subframes, size
+	}
+
+	// left, right, mid, side
+	midSide := frame.CodedChannels(frame.MidSideStereo, channels)
+	coded := [][]int32{channels[0], channels[1], midSide[0], midSide[1]}
+	codedBitsPerSample := []uint8{bitsPerSample, bitsPerSample, bitsPerSample, bitsPerSample + 1}
+
+	var subframes [4]*frame.Subframe
+	var sizes [4]uint64
+	encode := func(i int) {
+		if subframes[i] == nil {
+			subframe, size := frame.EncodeSubframe(coded[i], codedBitsPerSample[i], e.subframe)
+			subframes[i], sizes[i] = &subframe, size
+		}
+	}
+
+	if e.options.StereoMode == AdaptiveStereo {
+		for i := range coded {
+			sizes[i] = frame.EstimateSubframeSize(coded[i], codedBitsPerSample[i])
+		}
+	} else {
+		for i := range coded {
+			encode(i)
+		}
+	}
+
+	best := stereoAssigments[0]
+	for _, candidate := range stereoAssigments[1:] {
+		if sizes[candidate.channels[0]]+sizes[candidate.channels[1]] < sizes[best.channels[0]]+sizes[best.channels[1]] {
+			best = candidate
+		}
+	}
+	encode(best.channels[0])
+	encode(best.channels[1])
+
+	first, second := *subframes[best.channels[0]], *subframes[best.channels[1]]
+	first.Samples, second.Samples = channels[0], channels[1]
+	return best.assigment, []frame.Subframe{first, second}, sizes[best.channels[0]] + sizes[best.channels[1]]
+}
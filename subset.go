@@ -0,0 +1,147 @@
+package flac
+
+import (
+	"errors"
+	"fmt"
+	"frolovo22/flac/frame"
+	"frolovo22/flac/meta"
+	"io"
+	"os"
+)
+
+// maximum Rice partition order of the streamable subset
+const subsetMaxPartitionOrder = 8
+
+// maximum bits per sample of the streamable subset
+const subsetMaxBitsPerSample = 24
+
+// return maximum block size of the streamable subset for the sample rate
+func subsetMaxBlockSize(sampleRate uint32) int {
+	if sampleRate <= 48000 {
+		return 4608
+	}
+	return 16384
+}
+
+// return maximum LPC order of the streamable subset for the sample rate
+func subsetMaxLPCOrder(sampleRate uint32) int {
+	if sampleRate <= 48000 {
+		return 12
+	}
+	return frame.MaxLPCOrder
+}
+
+// check that encoder options produce the streamable subset
+// sample rate and sample size must have a code in the frame header, the encoder always stores them there
+func (eo *EncoderOptions) checkSubset() error {
+	if eo.BitsPerSample > subsetMaxBitsPerSample {
+		return errors.New("bits per sample is not in the streamable subset")
+	}
+	header := frame.NewFrameHeader(uint32(eo.BlockSize), eo.SampleRate, eo.BitsPerSample, frame.ChannelAssigment(eo.NumberOfChannels-1))
+	if header.SampleRate == 0 {
+		return errors.New("sample rate can not be stored in the frame header of the streamable subset")
+	}
+	if header.SampleSize == 0 {
+		return errors.New("bits per sample can not be stored in the frame header of the streamable subset")
+	}
+	if int(eo.BlockSize) > subsetMaxBlockSize(eo.SampleRate) {
+		return errors.New("block size is not in the streamable subset")
+	}
+	if eo.MaxLPCOrder > subsetMaxLPCOrder(eo.SampleRate) {
+		return errors.New("maximum LPC order is not in the streamable subset")
+	}
+	if eo.MaxPartitionOrder > subsetMaxPartitionOrder {
+		return errors.New("maximum partition order is not in the streamable subset")
+	}
+	return nil
+}
+
+// SubsetError describes a frame which is not in the streamable subset
+type SubsetError struct {
+	FrameNumber uint64 // number of the frame in the stream, counting from 0
+	Offset      int64  // byte offset of the frame in the stream
+	Reason      string
+}
+
+func (se *SubsetError) Error() string {
+	return fmt.Sprintf("frame %d at offset %d is not in the streamable subset: %s", se.FrameNumber, se.Offset, se.Reason)
+}
+
+// check that the file is in the streamable subset, see Decoder.CheckSubset
+func CheckSubsetFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder, err := NewDecoder(file)
+	if err != nil {
+		return err
+	}
+	return decoder.CheckSubset()
+}
+
+// decode all remaining frames and check that every frame header and subframe is in the streamable subset:
+// block size is at most 4608 for sample rates up to 48kHz and 16384 otherwise,
+// LPC order is at most 12 for sample rates up to 48kHz, Rice partition order is at most 8,
+// bits per sample is at most 24, sample rate and sample size are stored in the frame header
+// returns *SubsetError for the first frame out of the subset
+// must be called before the first frame is read
+func (d *Decoder) CheckSubset() error {
+	_, err := d.ReadMetadataBlocks()
+	if err != nil {
+		return err
+	}
+	streamInfo := d.StreamInfo()
+
+	for number := uint64(0); ; number++ {
+		offset := d.counter.offset
+		f, err := d.NextFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		reason := checkFrameSubset(f, streamInfo)
+		if reason != "" {
+			return &SubsetError{FrameNumber: number, Offset: offset, Reason: reason}
+		}
+	}
+}
+
+// return the reason why the frame is not in the streamable subset, empty if it is
+func checkFrameSubset(f *frame.Frame, streamInfo *meta.StreamInfo) string {
+	header := &f.Header
+	sampleRate := header.GetSampleRate(streamInfo)
+	blockSize := header.GetBlockSize()
+	if int(blockSize) > subsetMaxBlockSize(sampleRate) {
+		return fmt.Sprintf("block size %d", blockSize)
+	}
+
+	bitsPerSample := header.GetBitsPerSample(streamInfo)
+	if bitsPerSample > subsetMaxBitsPerSample {
+		return fmt.Sprintf("bits per sample %d", bitsPerSample)
+	}
+
+	// STREAMINFO must not be needed to decode the frame, also when there is no code for the value
+	if header.SampleRate == 0 {
+		return "sample rate is not stored in the frame header"
+	}
+	if header.SampleSize == 0 {
+		return "sample size is not stored in the frame header"
+	}
+
+	for i, subframe := range f.Subframes {
+		kind := subframe.Header.Type.Kind()
+		if kind == frame.LPCSubframe && subframe.Header.Type.Order() > subsetMaxLPCOrder(sampleRate) {
+			return fmt.Sprintf("subframe %d LPC order %d", i, subframe.Header.Type.Order())
+		}
+		if (kind == frame.FixedSubframe || kind == frame.LPCSubframe) && subframe.Residual.PartitionOrder > subsetMaxPartitionOrder {
+			return fmt.Sprintf("subframe %d partition order %d", i, subframe.Residual.PartitionOrder)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,329 @@
+package test
+
+import (
+	"bytes"
+	"frolovo22/flac"
+	"frolovo22/flac/frame"
+	"frolovo22/flac/meta"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecoderMetadataBlocks(t *testing.T) {
+	data, _ := encodeTestStream(t)
+	f, err := flac.Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.AddMetadataBlock(testMetadataBlocks()[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	err = f.Write(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := flac.NewDecoder(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoder.MetadataBlocks()) != 1 {
+		t.Errorf("%d metadata blocks are read by NewDecoder", len(decoder.MetadataBlocks()))
+	}
+
+	// the blocks which are not read yet are kept by NextFrame
+	_, err = decoder.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := decoder.MetadataBlocks()
+	if len(blocks) != 2 || blocks[1].Header.Type != meta.VorbisCommentBlockType {
+		t.Errorf("%d metadata blocks after the first frame", len(blocks))
+	}
+}
+
+// return stereo stream of one VERBATIM frame, sampleSize is the frame header code of bitsPerSample
+func pcmTestStream(t *testing.T, bitsPerSample int, sampleSize uint64, left []int64, right []int64) []byte {
+	t.Helper()
+	streamInfo := &meta.StreamInfo{MinimumBlockSize: 16, MaximumBlockSize: 16, SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: uint8(bitsPerSample)}
+	fb := &frameBuilder{}
+	fb.header(false, []byte{0}, len(left), 1, sampleSize)
+	for _, channel := range [][]int64{left, right} {
+		fb.subframeHeader(frame.VerbatimSubframe, 0)
+		for _, sample := range channel {
+			fb.signed(sample, bitsPerSample)
+		}
+	}
+	return buildTestStream(t, streamInfo, fb.end())
+}
+
+func TestPCMReader(t *testing.T) {
+	tests := []struct {
+		name          string
+		bitsPerSample int
+		sampleSize    uint64
+		left, right   []int64
+		setup         func(r *flac.PCMReader)
+		expected      []byte
+	}{
+		{"8 bit", 8, 1, []int64{1, -128}, []int64{127, -1}, func(r *flac.PCMReader) {},
+			[]byte{0x01, 0x7F, 0x80, 0xFF}},
+		{"8 bit unsigned", 8, 1, []int64{1, -128}, []int64{127, -1}, func(r *flac.PCMReader) { r.Unsigned8 = true },
+			[]byte{0x81, 0xFF, 0x00, 0x7F}},
+		{"12 bit", 12, 2, []int64{0x7FF, -1}, []int64{-0x800, 1}, func(r *flac.PCMReader) {},
+			[]byte{0xF0, 0x7F, 0x00, 0x80, 0xF0, 0xFF, 0x10, 0x00}},
+		{"16 bit", 16, 4, []int64{0x1234, -2}, []int64{-32768, 1}, func(r *flac.PCMReader) {},
+			[]byte{0x34, 0x12, 0x00, 0x80, 0xFE, 0xFF, 0x01, 0x00}},
+		{"16 bit big-endian", 16, 4, []int64{0x1234, -2}, []int64{-32768, 1}, func(r *flac.PCMReader) { r.BigEndian = true },
+			[]byte{0x12, 0x34, 0x80, 0x00, 0xFF, 0xFE, 0x00, 0x01}},
+		{"20 bit", 20, 5, []int64{0x12345, -1}, []int64{-0x80000, 0}, func(r *flac.PCMReader) {},
+			[]byte{0x50, 0x34, 0x12, 0x00, 0x00, 0x80, 0xF0, 0xFF, 0xFF, 0x00, 0x00, 0x00}},
+		{"24 bit", 24, 6, []int64{0x123456, -2}, []int64{-0x800000, 1}, func(r *flac.PCMReader) {},
+			[]byte{0x56, 0x34, 0x12, 0x00, 0x00, 0x80, 0xFE, 0xFF, 0xFF, 0x01, 0x00, 0x00}},
+		{"24 bit in 32 bit", 24, 6, []int64{0x123456, -2}, []int64{-0x800000, 1}, func(r *flac.PCMReader) { r.Pad24 = true },
+			[]byte{0x56, 0x34, 0x12, 0x00, 0x00, 0x00, 0x80, 0xFF, 0xFE, 0xFF, 0xFF, 0xFF, 0x01, 0x00, 0x00, 0x00}},
+		{"24 bit in 32 bit big-endian", 24, 6, []int64{0x123456, -2}, []int64{-0x800000, 1}, func(r *flac.PCMReader) { r.Pad24, r.BigEndian = true, true },
+			[]byte{0x00, 0x12, 0x34, 0x56, 0xFF, 0x80, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFE, 0x00, 0x00, 0x00, 0x01}},
+	}
+	for _, test := range tests {
+		stream := pcmTestStream(t, test.bitsPerSample, test.sampleSize, test.left, test.right)
+		decoder, err := flac.NewDecoder(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := flac.NewPCMReader(decoder)
+		test.setup(reader)
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, test.expected) {
+			t.Errorf("%s: % X, expected % X", test.name, data, test.expected)
+		}
+	}
+
+	// empty read does not decode a frame
+	stream := pcmTestStream(t, 16, 4, []int64{1, 2}, []int64{3, 4})
+	decoder, err := flac.NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := flac.NewPCMReader(decoder)
+	n, err := reader.Read(nil)
+	if n != 0 || err != nil {
+		t.Errorf("empty read: %d, %v", n, err)
+	}
+	_, err = decoder.NextFrame()
+	if err != nil {
+		t.Errorf("the frame is decoded by empty read: %v", err)
+	}
+	n, err = reader.Read(make([]byte, 1))
+	if n != 0 || err != io.EOF {
+		t.Errorf("read after the last frame: %d, %v", n, err)
+	}
+}
+
+const seekBlockSize, seekFrames = 1024, 120
+
+// sample n of the seek test stream
+func seekSample(n int) int64 {
+	return int64(n*7919%65536 - 32768)
+}
+
+// build stream of VERBATIM frames without SEEKTABLE, long enough for bisection search
+func seekTestStream(t *testing.T) ([]byte, *meta.StreamInfo) {
+	var data [][]byte
+	for number := 0; number < seekFrames; number++ {
+		fb := &frameBuilder{}
+		fb.header(false, []byte{byte(number)}, seekBlockSize, 0, 4)
+		fb.subframeHeader(frame.VerbatimSubframe, 0)
+		for i := 0; i < seekBlockSize; i++ {
+			fb.signed(seekSample(number*seekBlockSize+i), 16)
+		}
+		data = append(data, fb.end())
+	}
+	streamInfo := &meta.StreamInfo{MinimumBlockSize: seekBlockSize, MaximumBlockSize: seekBlockSize, SampleRate: 44100,
+		NumberOfChannels: 1, BitsPerSample: 16, TotalSamplesInStream: seekFrames * seekBlockSize}
+	return buildTestStream(t, streamInfo, data...), streamInfo
+}
+
+func TestSeekSampleWithoutSeekTable(t *testing.T) {
+	stream, streamInfo := seekTestStream(t)
+
+	decoder, err := flac.NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bisection is used for streams longer than the linear search distance
+	if len(stream) < 2*64*1024 {
+		t.Fatalf("stream of %d bytes is too short", len(stream))
+	}
+	for _, n := range []int{60*seekBlockSize + 5, 0, seekBlockSize - 1, seekBlockSize, 50000, seekFrames*seekBlockSize - 1, 3} {
+		err = decoder.SeekSample(uint64(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := decoder.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples := f.Subframes[0].Samples
+		if len(samples) != seekBlockSize-n%seekBlockSize || int64(samples[0]) != seekSample(n) {
+			t.Errorf("seek to %d: %d samples, the first is %d, expected %d", n, len(samples), samples[0], seekSample(n))
+		}
+		// the header is of the whole frame
+		if f.Header.GetSampleNumber(streamInfo) != uint64(n-n%seekBlockSize) || f.Header.GetBlockSize() != seekBlockSize {
+			t.Errorf("seek to %d: frame header of sample %d", n, f.Header.GetSampleNumber(streamInfo))
+		}
+		if n/seekBlockSize < seekFrames-1 {
+			f, err = decoder.NextFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(f.Subframes[0].Samples[0]) != seekSample((n/seekBlockSize+1)*seekBlockSize) {
+				t.Errorf("seek to %d: next frame is not decoded", n)
+			}
+		}
+	}
+	if decoder.SeekSample(seekFrames*seekBlockSize) == nil {
+		t.Error("seek after the last sample")
+	}
+
+	// MD5 signature can not be checked after seeking
+	if decoder.CheckMD5() != flac.ErrMD5Seeked || decoder.EnableMD5() != flac.ErrMD5Seeked {
+		t.Error("MD5 signature is checked after seeking")
+	}
+}
+
+func TestSeekSampleAfterPrefix(t *testing.T) {
+	bisection, _ := seekTestStream(t)
+	samples := generateSamples(1, 50000, 16)
+	withSeekTable := encode(t, flac.EncoderOptions{SampleRate: 44100, NumberOfChannels: 1, BitsPerSample: 16,
+		BlockSize: 1000, SeekPoints: 10, TotalSamples: 50000}, samples)
+
+	// the stream is embedded after bytes which look like frame sync codes
+	prefix := bytes.Repeat([]byte{0xFF, 0xF8, 0x69, 0x08}, 1000)
+	for _, test := range []struct {
+		stream []byte
+		sample func(n int) int64
+	}{
+		{bisection, seekSample},
+		{withSeekTable, func(n int) int64 { return int64(samples[0][n]) }},
+	} {
+		reader := bytes.NewReader(append(append([]byte{}, prefix...), test.stream...))
+		_, err := reader.Seek(int64(len(prefix)), io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder, err := flac.NewDecoder(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := decoder.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(f.Subframes[0].Samples[0]) != test.sample(0) {
+			t.Error("the first frame is decoded incorrectly")
+		}
+
+		for _, n := range []int{30000, 5, 49999, 1000} {
+			err = decoder.SeekSample(uint64(n))
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err = decoder.NextFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(f.Subframes[0].Samples[0]) != test.sample(n) {
+				t.Errorf("seek to %d returns sample %d, expected %d", n, f.Subframes[0].Samples[0], test.sample(n))
+			}
+		}
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	options := flac.EncoderOptions{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, BlockSize: 1024}
+	samples := generateSamples(2, 20000, 16)
+	changed := generateSamples(2, 20000, 16)
+	changed[1][12345]++
+
+	// encode to a file, so the MD5 signature is written to STREAMINFO
+	encodeFile := func(samples [][]int32) []byte {
+		file, err := ioutil.TempFile("", "encoder*.flac")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		encodeTo(t, file, options, samples)
+		data, err := ioutil.ReadFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	data := encodeFile(samples)
+
+	// MD5 signature is the last field of STREAMINFO
+	const signatureOffset = 4 + 4 + 34 - 16
+	wrongSignature := append([]byte{}, data...)
+	wrongSignature[signatureOffset] ^= 0xFF
+	wrongSample := encodeFile(changed)
+	copy(wrongSample[signatureOffset:signatureOffset+16], data[signatureOffset:])
+
+	dir, err := ioutil.TempDir("", "flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.flac")
+
+	for _, stream := range [][]byte{wrongSignature, wrongSample} {
+		decoder, err := flac.NewDecoder(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = decoder.Verify()
+		if err != flac.ErrMD5Mismatch {
+			t.Errorf("expected %v, got %v", flac.ErrMD5Mismatch, err)
+		}
+
+		err = ioutil.WriteFile(path, stream, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = flac.VerifyFile(path)
+		if err != flac.ErrMD5Mismatch {
+			t.Errorf("expected %v, got %v", flac.ErrMD5Mismatch, err)
+		}
+	}
+
+	// the signature can not be computed after a frame is read
+	decoder, err := flac.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decoder.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoder.EnableMD5() != flac.ErrMD5FramesRead || decoder.Verify() != flac.ErrMD5FramesRead {
+		t.Error("MD5 signature is computed after a frame is read")
+	}
+
+	decoder, err = flac.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = decoder.Verify()
+	if err != nil {
+		t.Error(err)
+	}
+}
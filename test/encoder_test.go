@@ -0,0 +1,649 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"frolovo22/flac"
+	"frolovo22/flac/frame"
+	"github.com/icza/bitio"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// generate test signal: tone with noise, silence and samples with wasted bits
+func generateSamples(channels int, length int, bitsPerSample uint8) [][]int32 {
+	random := rand.New(rand.NewSource(1))
+	amplitude := float64(int64(1)<<(bitsPerSample-1)-1) / 2
+	samples := make([][]int32, channels)
+	for c := range samples {
+		samples[c] = make([]int32, length)
+		for i := range samples[c] {
+			switch {
+			case i < length/4:
+				value := amplitude*math.Sin(float64(i*(c+1))/20) + random.NormFloat64()*amplitude/100
+				samples[c][i] = int32(value)
+			case i < length/2:
+				samples[c][i] = 0
+			case i < 3*length/4:
+				samples[c][i] = int32(amplitude*math.Sin(float64(i)/30)) &^ 7
+			default:
+				samples[c][i] = int32(random.Int63n(int64(amplitude)) - int64(amplitude)/2)
+			}
+		}
+	}
+	return samples
+}
+
+// generate correlated stereo signal: the same tone with a phase shift, identical channels and channels with opposite sign
+func generateStereoSamples(length int, bitsPerSample uint8) [][]int32 {
+	random := rand.New(rand.NewSource(1))
+	amplitude := float64(int64(1)<<(bitsPerSample-1)-1) / 2
+	samples := [][]int32{make([]int32, length), make([]int32, length)}
+	for i := 0; i < length; i++ {
+		left := amplitude * math.Sin(float64(i)/20)
+		samples[0][i] = int32(left + random.NormFloat64()*amplitude/100)
+		switch {
+		case i < length/3:
+			samples[1][i] = int32(amplitude*math.Sin(float64(i)/20+0.1) + random.NormFloat64()*amplitude/100)
+		case i < 2*length/3:
+			samples[1][i] = samples[0][i]
+		default:
+			samples[1][i] = -samples[0][i]
+		}
+	}
+	return samples
+}
+
+func encode(t *testing.T, options flac.EncoderOptions, samples [][]int32) []byte {
+	var buffer bytes.Buffer
+	encodeTo(t, &buffer, options, samples)
+	return buffer.Bytes()
+}
+
+func encodeTo(t *testing.T, writer io.Writer, options flac.EncoderOptions, samples [][]int32) *flac.Encoder {
+	encoder, err := flac.NewEncoder(writer, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write in uneven chunks
+	for start := 0; start < len(samples[0]); start += 1000 {
+		end := start + 1000
+		if end > len(samples[0]) {
+			end = len(samples[0])
+		}
+		chunk := make([][]int32, len(samples))
+		for c := range samples {
+			chunk[c] = samples[c][start:end]
+		}
+		err = encoder.WriteSamples(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = encoder.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoder
+}
+
+func decode(t *testing.T, data []byte) [][]int32 {
+	decoder, err := flac.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make([][]int32, decoder.StreamInfo().NumberOfChannels)
+	for {
+		f, err := decoder.NextFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c, subframe := range f.Subframes {
+			samples[c] = append(samples[c], subframe.Samples...)
+		}
+	}
+	return samples
+}
+
+func compareSamples(t *testing.T, expected [][]int32, actual [][]int32) {
+	if len(expected) != len(actual) {
+		t.Fatalf("channels: expected %d, got %d", len(expected), len(actual))
+	}
+	for c := range expected {
+		if len(expected[c]) != len(actual[c]) {
+			t.Fatalf("channel %d samples: expected %d, got %d", c, len(expected[c]), len(actual[c]))
+		}
+		for i := range expected[c] {
+			if expected[c][i] != actual[c][i] {
+				t.Fatalf("channel %d sample %d: expected %d, got %d", c, i, expected[c][i], actual[c][i])
+			}
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, options := range []flac.EncoderOptions{
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16},
+		{SampleRate: 48000, NumberOfChannels: 1, BitsPerSample: 24, BlockSize: 1152},
+		{SampleRate: 22050, NumberOfChannels: 3, BitsPerSample: 8, BlockSize: 200},
+		{SampleRate: 96000, NumberOfChannels: 2, BitsPerSample: 20, BlockSize: 4608},
+		{SampleRate: 37000, NumberOfChannels: 2, BitsPerSample: 12, BlockSize: 1000},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, MaxLPCOrder: 8},
+		{SampleRate: 48000, NumberOfChannels: 1, BitsPerSample: 24, MaxLPCOrder: 32, ExhaustiveOrderSearch: true},
+		{SampleRate: 8000, NumberOfChannels: 1, BitsPerSample: 16, BlockSize: 192, MaxLPCOrder: 12, QLPPrecision: 15,
+			Windows: frame.SubdivideTukeyWindows(3, 0.5)},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, MaxLPCOrder: 8, MaxPartitionOrder: 6},
+		{SampleRate: 192000, NumberOfChannels: 1, BitsPerSample: 32, BlockSize: 4000, MaxLPCOrder: 4, MaxPartitionOrder: 15},
+	} {
+		samples := generateSamples(int(options.NumberOfChannels), 20000, options.BitsPerSample)
+		data := encode(t, options, samples)
+		compareSamples(t, samples, decode(t, data))
+	}
+}
+
+func TestEncodeStereo(t *testing.T) {
+	for _, options := range []flac.EncoderOptions{
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, MaxLPCOrder: 8, StereoMode: flac.ExhaustiveStereo},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 24, MaxLPCOrder: 8, StereoMode: flac.AdaptiveStereo},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 32, StereoMode: flac.ExhaustiveStereo},
+	} {
+		samples := generateStereoSamples(20000, options.BitsPerSample)
+		data := encode(t, options, samples)
+		compareSamples(t, samples, decode(t, data))
+
+		// correlated channels are decorrelated, except 32 bit ones which side channel does not fit
+		decoder, err := flac.NewDecoder(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decorrelated := false
+		for {
+			f, err := decoder.NextFrame()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			decorrelated = decorrelated || f.Header.ChannelAssigment.SideChannel() >= 0
+		}
+		if decorrelated != (options.BitsPerSample < 32) {
+			t.Errorf("%d bit stereo is decorrelated: %v", options.BitsPerSample, decorrelated)
+		}
+	}
+}
+
+func TestEncodeLevels(t *testing.T) {
+	samples := generateSamples(2, 20000, 16)
+	for level := 0; level <= 8; level++ {
+		options := flac.Level(level)
+		options.SampleRate = 44100
+		options.NumberOfChannels = 2
+		options.BitsPerSample = 16
+		data := encode(t, options, samples)
+		compareSamples(t, samples, decode(t, data))
+	}
+}
+
+func TestLevel(t *testing.T) {
+	subdivide2, subdivide3 := len(frame.SubdivideTukeyWindows(2, 0.5)), len(frame.SubdivideTukeyWindows(3, 0.5))
+	tests := []struct {
+		blockSize         uint16
+		maxLPCOrder       int
+		windows           int
+		maxPartitionOrder int
+		stereoMode        flac.StereoMode
+	}{
+		{1152, 0, 1, 3, flac.IndependentStereo},
+		{1152, 0, 1, 3, flac.AdaptiveStereo},
+		{1152, 0, 1, 3, flac.ExhaustiveStereo},
+		{4096, 6, 1, 4, flac.IndependentStereo},
+		{4096, 8, 1, 4, flac.AdaptiveStereo},
+		{4096, 8, 1, 5, flac.ExhaustiveStereo},
+		{4096, 8, subdivide2, 6, flac.ExhaustiveStereo},
+		{4096, 12, subdivide2, 6, flac.ExhaustiveStereo},
+		{4096, 12, subdivide3, 6, flac.ExhaustiveStereo},
+	}
+	for level, test := range tests {
+		options := flac.Level(level)
+		if options.BlockSize != test.blockSize || options.MaxLPCOrder != test.maxLPCOrder || len(options.Windows) != test.windows ||
+			options.MaxPartitionOrder != test.maxPartitionOrder || options.StereoMode != test.stereoMode {
+			t.Errorf("level %d: block size %d, LPC order %d, %d windows, partition order %d, stereo %v", level,
+				options.BlockSize, options.MaxLPCOrder, len(options.Windows), options.MaxPartitionOrder, options.StereoMode)
+		}
+		if options.VariableBlockSize || options.ExhaustiveOrderSearch || options.QLPPrecision != 0 {
+			t.Errorf("level %d: %+v", level, options)
+		}
+	}
+
+	// levels out of range are clamped
+	for level, clamped := range map[int]int{-1: 0, 9: 8} {
+		options, expected := flac.Level(level), flac.Level(clamped)
+		if options.BlockSize != expected.BlockSize || options.MaxLPCOrder != expected.MaxLPCOrder ||
+			len(options.Windows) != len(expected.Windows) || options.StereoMode != expected.StereoMode {
+			t.Errorf("level %d is not level %d", level, clamped)
+		}
+	}
+}
+
+func TestEncodeVariableBlockSize(t *testing.T) {
+	options := flac.EncoderOptions{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, MaxLPCOrder: 8}
+	samples := generateSamples(2, 20000, 16)
+	fixed := encode(t, options, samples)
+
+	options.VariableBlockSize = true
+	options.MinBlockSize = 256
+	var buffer bytes.Buffer
+	encoder := encodeTo(t, &buffer, options, samples)
+	data := buffer.Bytes()
+	compareSamples(t, samples, decode(t, data))
+	if len(data) >= len(fixed) {
+		t.Errorf("variable block size stream is not smaller: %d >= %d", len(data), len(fixed))
+	}
+
+	decoder, err := flac.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamInfo := decoder.StreamInfo()
+	var sampleNumber uint64
+	var minimumBlockSize, maximumBlockSize uint32
+	for {
+		f, err := decoder.NextFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Header.BlockingStrategy != frame.VariableBlockSizeStream {
+			t.Fatal("frame header is not variable block size")
+		}
+		if f.Header.GetSampleNumber(streamInfo) != sampleNumber {
+			t.Fatalf("sample number %d, expected %d", f.Header.GetSampleNumber(streamInfo), sampleNumber)
+		}
+		blockSize := f.Header.GetBlockSize()
+		if blockSize > uint32(streamInfo.MaximumBlockSize) {
+			t.Errorf("block size %d is greater than maximum %d", blockSize, streamInfo.MaximumBlockSize)
+		}
+		sampleNumber += uint64(blockSize)
+		if sampleNumber < 20000 && blockSize < uint32(streamInfo.MinimumBlockSize) {
+			t.Errorf("block size %d is less than minimum %d", blockSize, streamInfo.MinimumBlockSize)
+		}
+		if sampleNumber < 20000 && (minimumBlockSize == 0 || blockSize < minimumBlockSize) {
+			minimumBlockSize = blockSize
+		}
+		if blockSize > maximumBlockSize {
+			maximumBlockSize = blockSize
+		}
+	}
+	if sampleNumber != 20000 {
+		t.Errorf("decoded %d samples", sampleNumber)
+	}
+	// STREAMINFO holds the block sizes used, not the bounds of the options
+	final := encoder.StreamInfo()
+	if uint32(final.MinimumBlockSize) != minimumBlockSize || uint32(final.MaximumBlockSize) != maximumBlockSize {
+		t.Errorf("STREAMINFO block sizes %d-%d, used %d-%d", final.MinimumBlockSize, final.MaximumBlockSize, minimumBlockSize, maximumBlockSize)
+	}
+
+	// the only frame is shorter than 16 samples, STREAMINFO block sizes are not
+	encoder = encodeTo(t, ioutil.Discard, options, [][]int32{samples[0][:10], samples[1][:10]})
+	final = encoder.StreamInfo()
+	if final.MinimumBlockSize != 16 || final.MaximumBlockSize != 16 {
+		t.Errorf("STREAMINFO block sizes %d-%d of 10 samples, expected 16-16", final.MinimumBlockSize, final.MaximumBlockSize)
+	}
+
+	options.Subset = true
+	options.BlockSize = 8192
+	_, err = flac.NewEncoder(&bytes.Buffer{}, options)
+	if err == nil {
+		t.Error("block size 8192 is accepted in the streamable subset")
+	}
+}
+
+func TestEncodeStreamInfo(t *testing.T) {
+	options := flac.EncoderOptions{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 24, MaxLPCOrder: 8,
+		VariableBlockSize: true, MinBlockSize: 512}
+	samples := generateSamples(2, 20000, 24)
+
+	file, err := ioutil.TempFile("", "encoder*.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	encoder := encodeTo(t, file, options, samples)
+	expected := encoder.StreamInfo()
+	if expected.TotalSamplesInStream != 20000 || expected.MinimumFrameSize == 0 ||
+		expected.MinimumFrameSize > expected.MaximumFrameSize || expected.MinimumBlockSize > expected.MaximumBlockSize {
+		t.Fatalf("incorrect STREAMINFO %+v", expected)
+	}
+
+	// STREAMINFO is rewritten in the file
+	err = flac.VerifyFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := flac.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.StreamInfo(), expected) {
+		t.Errorf("STREAMINFO %+v, expected %+v", f.StreamInfo(), expected)
+	}
+
+	// the same audio without seeking keeps zeros in the stream
+	var buffer bytes.Buffer
+	encoder = encodeTo(t, &buffer, options, samples)
+	if !reflect.DeepEqual(encoder.StreamInfo(), expected) {
+		t.Errorf("STREAMINFO %+v, expected %+v", encoder.StreamInfo(), expected)
+	}
+	decoder, err := flac.NewDecoder(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoder.StreamInfo().TotalSamplesInStream != 0 {
+		t.Error("total samples are written without seeking")
+	}
+	err = decoder.Verify()
+	if err != flac.ErrMD5NotSet {
+		t.Errorf("expected %v, got %v", flac.ErrMD5NotSet, err)
+	}
+}
+
+func TestEncodeSeekTable(t *testing.T) {
+	samples := generateSamples(1, 20000, 16)
+	for _, options := range []flac.EncoderOptions{
+		{SampleRate: 44100, NumberOfChannels: 1, BitsPerSample: 16, SeekPoints: 5, TotalSamples: 20000},
+		{SampleRate: 44100, NumberOfChannels: 1, BitsPerSample: 16, SeekPoints: 10, SeekPointInterval: 4410,
+			VariableBlockSize: true, MinBlockSize: 256},
+		{SampleRate: 8000, NumberOfChannels: 1, BitsPerSample: 16, BlockSize: 1000, SeekPointInterval: 8000, TotalSamples: 20000},
+		{SampleRate: 8000, NumberOfChannels: 1, BitsPerSample: 16, BlockSize: 1000, SeekPointSeconds: 0.5, TotalSamples: 20000},
+	} {
+		file, err := ioutil.TempFile("", "encoder*.flac")
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoder := encodeTo(t, file, options, samples)
+		file.Close()
+		data, err := ioutil.ReadFile(file.Name())
+		os.Remove(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := flac.Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seekTable := f.SeekTable()
+		if seekTable == nil || !reflect.DeepEqual(seekTable, encoder.SeekTable()) {
+			t.Fatalf("SEEKTABLE %+v, expected %+v", seekTable, encoder.SeekTable())
+		}
+
+		// marker, STREAMINFO and SEEKTABLE
+		audioOffset := 4 + 4 + 34 + 4 + 18*len(seekTable.SeekPoints)
+		streamInfo := f.StreamInfo()
+		used := 0
+		for i, point := range seekTable.SeekPoints {
+			if point.IsPlaceholder() {
+				continue
+			}
+			if used != i {
+				t.Fatal("placeholder point before used point")
+			}
+			used++
+			reader := bitio.NewReader(bytes.NewReader(data[audioOffset+int(point.Offset):]))
+			header, err := frame.ReadFrameHeader(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.GetSampleNumber(streamInfo) != point.SampleNumberOfFirstSample || header.GetBlockSize() != uint32(point.NumberOfSamples) {
+				t.Errorf("seek point %+v does not match frame header %+v", point, header)
+			}
+		}
+		if used < 2 {
+			t.Errorf("%d seek points are used", used)
+		}
+
+		decoder, err := flac.NewDecoder(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = decoder.SeekSample(15000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := decoder.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next.Subframes[0].Samples[0] != samples[0][15000] {
+			t.Error("seek to sample 15000 returns another sample")
+		}
+	}
+
+	// one point every half second of 20000 samples at 8000 Hz
+	encoder := encodeTo(t, ioutil.Discard, flac.EncoderOptions{SampleRate: 8000, NumberOfChannels: 1, BitsPerSample: 16,
+		BlockSize: 1000, SeekPointSeconds: 0.5, TotalSamples: 20000}, samples)
+	if len(encoder.SeekTable().SeekPoints) != 5 {
+		t.Errorf("%d seek points, expected 5", len(encoder.SeekTable().SeekPoints))
+	}
+
+	for _, options := range []flac.EncoderOptions{
+		{SeekPointInterval: 4410},
+		{SeekPointSeconds: 1},
+		{SeekPoints: 5},
+		{SeekPointInterval: 4410, SeekPointSeconds: 1, SeekPoints: 5},
+		{SeekPointSeconds: -1, SeekPoints: 5},
+		{SeekPoints: -1},
+		{SeekPointInterval: 1, TotalSamples: 1 << 40},
+	} {
+		options.SampleRate, options.NumberOfChannels, options.BitsPerSample = 44100, 1, 16
+		_, err := flac.NewEncoder(ioutil.Discard, options)
+		if err == nil {
+			t.Errorf("encoder with seek point options %+v is created", options)
+		}
+	}
+}
+
+func TestEncodeConcurrency(t *testing.T) {
+	samples := generateSamples(2, 50000, 16)
+	for _, options := range []flac.EncoderOptions{
+		flac.Level(5),
+		{BlockSize: 1152, MaxLPCOrder: 8, VariableBlockSize: true, SeekPoints: 4, TotalSamples: 50000},
+	} {
+		options.SampleRate, options.NumberOfChannels, options.BitsPerSample = 44100, 2, 16
+		expected := encode(t, options, samples)
+		for _, concurrency := range []int{2, 3, 32} {
+			options.Concurrency = concurrency
+			if !bytes.Equal(encode(t, options, samples), expected) {
+				t.Errorf("stream encoded with concurrency %d differs", concurrency)
+			}
+		}
+	}
+
+	// at most Concurrency blocks are kept in flight, the others are written by WriteSamples
+	options := flac.EncoderOptions{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, BlockSize: 1000, Concurrency: 3}
+	var buffer bytes.Buffer
+	encoder, err := flac.NewEncoder(&buffer, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encoder.WriteSamples([][]int32{samples[0][:10000], samples[1][:10000]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := flac.NewDecoder(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := 0
+	for {
+		_, err = decoder.NextFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames++
+	}
+	if frames != 8 {
+		t.Errorf("%d frames of 10 blocks are written before Close, expected 8", frames)
+	}
+	err = encoder.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubset(t *testing.T) {
+	samples := generateSamples(2, 20000, 16)
+	checkSubset := func(data []byte) error {
+		decoder, err := flac.NewDecoder(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return decoder.CheckSubset()
+	}
+
+	options := flac.Level(8)
+	options.SampleRate, options.NumberOfChannels, options.BitsPerSample = 44100, 2, 16
+	options.Subset = true
+	err := checkSubset(encode(t, options, samples))
+	if err != nil {
+		t.Error(err)
+	}
+
+	options.BlockSize = 8192
+	options.Subset = false
+	err = checkSubset(encode(t, options, samples))
+	if subsetErr, ok := err.(*flac.SubsetError); !ok || subsetErr.FrameNumber != 0 {
+		t.Errorf("expected *flac.SubsetError of the first frame, got %v", err)
+	}
+
+	// bits per sample over 24, sample size and sample rate without a code in the frame header
+	for _, options := range []flac.EncoderOptions{
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 28},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 17},
+		{SampleRate: 100001, NumberOfChannels: 2, BitsPerSample: 16},
+	} {
+		err = checkSubset(encode(t, options, samples))
+		if subsetErr, ok := err.(*flac.SubsetError); !ok || subsetErr.FrameNumber != 0 {
+			t.Errorf("expected *flac.SubsetError of the first frame of %+v, got %v", options, err)
+		}
+	}
+
+	for _, options := range []flac.EncoderOptions{
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, Subset: true, BlockSize: 8192},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, Subset: true, MaxLPCOrder: 32},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, Subset: true, MaxPartitionOrder: 9},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 32, Subset: true},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 17, Subset: true},
+		{SampleRate: 100001, NumberOfChannels: 2, BitsPerSample: 16, Subset: true},
+	} {
+		_, err = flac.NewEncoder(&bytes.Buffer{}, options)
+		if err == nil {
+			t.Errorf("options out of the streamable subset are accepted: %+v", options)
+		}
+	}
+}
+
+// failingWriter accepts limit bytes, then fails every write
+type failingWriter struct {
+	written bytes.Buffer
+	limit   int
+	writes  int // writes after the failure
+}
+
+var errWriter = errors.New("writer failed")
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if fw.written.Len() >= fw.limit {
+		fw.writes++
+		return 0, errWriter
+	}
+	if fw.written.Len()+len(p) > fw.limit {
+		n := fw.limit - fw.written.Len()
+		fw.written.Write(p[:n])
+		return n, errWriter
+	}
+	return fw.written.Write(p)
+}
+
+func TestEncodeWriteError(t *testing.T) {
+	options := flac.EncoderOptions{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, BlockSize: 1000, Concurrency: 3}
+	samples := generateSamples(2, 50000, 16)
+	expected := encode(t, options, samples)
+
+	writer := &failingWriter{limit: len(expected) / 2}
+	encoder, err := flac.NewEncoder(writer, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := 0; start < len(samples[0]); start += 2500 {
+		err = encoder.WriteSamples([][]int32{samples[0][start : start+2500], samples[1][start : start+2500]})
+		if err != nil {
+			break
+		}
+	}
+	if err != errWriter {
+		t.Fatalf("expected %v, got %v", errWriter, err)
+	}
+	writes := writer.writes
+
+	// the encoder stays failed and does not write the blocks in flight or the buffered samples
+	err = encoder.WriteSamples([][]int32{samples[0][:2500], samples[1][:2500]})
+	if err != errWriter {
+		t.Errorf("WriteSamples after the failure: expected %v, got %v", errWriter, err)
+	}
+	err = encoder.Close()
+	if err != errWriter {
+		t.Errorf("Close after the failure: expected %v, got %v", errWriter, err)
+	}
+	if writer.writes != writes {
+		t.Errorf("%d writes after the failure", writer.writes-writes)
+	}
+	if !bytes.Equal(writer.written.Bytes(), expected[:writer.limit]) {
+		t.Error("stream before the failure differs")
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	for _, options := range []flac.EncoderOptions{
+		{SampleRate: 0, NumberOfChannels: 2, BitsPerSample: 16},
+		{SampleRate: 1 << 20, NumberOfChannels: 2, BitsPerSample: 16},
+		{SampleRate: 44100, NumberOfChannels: 0, BitsPerSample: 16},
+		{SampleRate: 44100, NumberOfChannels: 9, BitsPerSample: 16},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 3},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, BlockSize: 15},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, VariableBlockSize: true, BlockSize: 1024, MinBlockSize: 2048},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, MaxLPCOrder: 33},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, MaxPartitionOrder: 16},
+		{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, Concurrency: -1},
+	} {
+		_, err := flac.NewEncoder(ioutil.Discard, options)
+		if err == nil {
+			t.Errorf("encoder with incorrect options %+v is created", options)
+		}
+	}
+
+	// the largest sample rate of STREAMINFO
+	options := flac.EncoderOptions{SampleRate: 1<<20 - 1, NumberOfChannels: 2, BitsPerSample: 16}
+	f, err := flac.Read(bytes.NewReader(encode(t, options, generateSamples(2, 5000, 16))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.StreamInfo().SampleRate != options.SampleRate {
+		t.Errorf("sample rate %d, expected %d", f.StreamInfo().SampleRate, options.SampleRate)
+	}
+}
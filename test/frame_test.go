@@ -0,0 +1,529 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"frolovo22/flac"
+	"frolovo22/flac/frame"
+	"frolovo22/flac/meta"
+	"github.com/icza/bitio"
+	"reflect"
+	"testing"
+)
+
+// frameBuilder writes frames bit by bit independently of the encoder, so the decoder is checked against hand-built frames
+type frameBuilder struct {
+	data []byte
+	bits int
+}
+
+// write the lowest n bits of value
+func (fb *frameBuilder) write(value uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if fb.bits%8 == 0 {
+			fb.data = append(fb.data, 0)
+		}
+		if value>>uint(i)&1 != 0 {
+			fb.data[len(fb.data)-1] |= 0x80 >> uint(fb.bits%8)
+		}
+		fb.bits++
+	}
+}
+
+// write n bits signed two's-complement value
+func (fb *frameBuilder) signed(value int64, n int) {
+	fb.write(uint64(value)&(1<<uint(n)-1), n)
+}
+
+// write Rice coded value: folded value, quotient in unary, k bits of remainder
+func (fb *frameBuilder) rice(value int64, k int) {
+	folded := uint64(value<<1) ^ uint64(value>>63)
+	for q := folded >> uint(k); q > 0; q-- {
+		fb.write(0, 1)
+	}
+	fb.write(1, 1)
+	fb.write(folded, k)
+}
+
+// write frame header: the block size is stored in 16 bits at the end of header,
+// the sample rate is 44.1kHz, the sample size code 0 means the size from STREAMINFO
+// number is the UTF-8 coded frame number, or sample number if variable is set
+func (fb *frameBuilder) header(variable bool, number []byte, blockSize int, channelAssigment uint64, sampleSize uint64) {
+	fb.write(0x3FFE, 14)
+	fb.write(0, 1)
+	if variable {
+		fb.write(1, 1)
+	} else {
+		fb.write(0, 1)
+	}
+	fb.write(7, 4)
+	fb.write(9, 4)
+	fb.write(channelAssigment, 4)
+	fb.write(sampleSize, 3)
+	fb.write(0, 1)
+	for _, b := range number {
+		fb.write(uint64(b), 8)
+	}
+	fb.write(uint64(blockSize-1), 16)
+	fb.write(uint64(testCRC8(fb.data)), 8)
+}
+
+// write subframe header of type with wasted bits
+func (fb *frameBuilder) subframeHeader(subframeType frame.SubframeType, wastedBits int) {
+	fb.write(0, 1)
+	fb.write(uint64(subframeType), 6)
+	if wastedBits == 0 {
+		fb.write(0, 1)
+		return
+	}
+	fb.write(1, 1)
+	// k-1 zeros and one
+	fb.write(1, wastedBits)
+}
+
+// partition of residual: Rice parameter, or bits per sample of escaped partition
+type testPartition struct {
+	parameter int
+	escaped   bool
+}
+
+// write residual values of samples from order to the end of the block
+func (fb *frameBuilder) residual(method frame.ResidualCodingMethod, partitionOrder int, partitions []testPartition, values []int64, order int) {
+	fb.write(uint64(method), 2)
+	fb.write(uint64(partitionOrder), 4)
+	partitionSize := (len(values) + order) >> uint(partitionOrder)
+	i := 0
+	for p, partition := range partitions {
+		size := partitionSize
+		if p == 0 {
+			size -= order
+		}
+		if partition.escaped {
+			fb.write(uint64(method.EscapeCode()), int(method.ParameterSize()))
+			fb.write(uint64(partition.parameter), 5)
+			for _, value := range values[i : i+size] {
+				fb.signed(value, partition.parameter)
+			}
+		} else {
+			fb.write(uint64(partition.parameter), int(method.ParameterSize()))
+			for _, value := range values[i : i+size] {
+				fb.rice(value, partition.parameter)
+			}
+		}
+		i += size
+	}
+}
+
+// pad to byte and write CRC-16, returns the frame
+func (fb *frameBuilder) end() []byte {
+	fb.write(0, (8-fb.bits%8)%8)
+	fb.write(uint64(testCRC16(fb.data)), 16)
+	return fb.data
+}
+
+// bitwise CRC-8, polynomial x^8 + x^2 + x^1 + x^0
+func testCRC8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// bitwise CRC-16, polynomial x^16 + x^15 + x^2 + x^0
+func testCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// residual of FIXED predictor: order-th difference of the samples from order
+func fixedTestResidual(samples []int64, order int) []int64 {
+	values := append([]int64{}, samples...)
+	for o := 0; o < order; o++ {
+		for i := len(values) - 1; i > o; i-- {
+			values[i] -= values[i-1]
+		}
+	}
+	return values[order:]
+}
+
+func readTestFrame(t *testing.T, data []byte, streamInfo *meta.StreamInfo) *frame.Frame {
+	t.Helper()
+	f, err := frame.ReadFrame(bitio.NewReader(bytes.NewReader(data)), streamInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func checkTestSamples(t *testing.T, name string, samples []int32, expected []int64) {
+	t.Helper()
+	if len(samples) != len(expected) {
+		t.Fatalf("%s: %d samples, expected %d", name, len(samples), len(expected))
+	}
+	for i := range samples {
+		if int64(samples[i]) != expected[i] {
+			t.Errorf("%s: sample %d is %d, expected %d", name, i, samples[i], expected[i])
+			return
+		}
+	}
+}
+
+func TestReadSubframes(t *testing.T) {
+	const blockSize = 16
+	samples := make([]int64, blockSize)
+	quadratic := make([]int64, blockSize)
+	for i := range samples {
+		samples[i] = int64(i*7919%2000 - 1000)
+		quadratic[i] = int64(3*i*i - 40*i + 7)
+	}
+
+	// mono 16 bit frame with one subframe
+	build := func(subframe func(fb *frameBuilder)) []byte {
+		fb := &frameBuilder{}
+		fb.header(false, []byte{0}, blockSize, 0, 4)
+		subframe(fb)
+		return fb.end()
+	}
+
+	constant := make([]int64, blockSize)
+	wasted := make([]int64, blockSize)
+	for i := range constant {
+		constant[i] = -1234
+		wasted[i] = samples[i] * 8
+	}
+	type subframeTest struct {
+		name     string
+		frame    []byte
+		expected []int64
+	}
+	tests := []subframeTest{
+		{"CONSTANT", build(func(fb *frameBuilder) {
+			fb.subframeHeader(frame.ConstantSubframe, 0)
+			fb.signed(-1234, 16)
+		}), constant},
+		{"VERBATIM", build(func(fb *frameBuilder) {
+			fb.subframeHeader(frame.VerbatimSubframe, 0)
+			for _, sample := range samples {
+				fb.signed(sample, 16)
+			}
+		}), samples},
+		{"VERBATIM with wasted bits", build(func(fb *frameBuilder) {
+			fb.subframeHeader(frame.VerbatimSubframe, 3)
+			for _, sample := range samples {
+				fb.signed(sample, 13)
+			}
+		}), wasted},
+	}
+	for order := 0; order <= 4; order++ {
+		order := order
+		tests = append(tests, subframeTest{fmt.Sprintf("FIXED order %d", order), build(func(fb *frameBuilder) {
+			fb.subframeHeader(frame.FixedSubframe+frame.SubframeType(order), 0)
+			for _, sample := range quadratic[:order] {
+				fb.signed(sample, 16)
+			}
+			fb.residual(frame.RiceCodingMethod, 0, []testPartition{{parameter: 6}}, fixedTestResidual(quadratic, order), order)
+		}), quadratic})
+	}
+
+	// LPC order 2, coefficients 7 and -3 with 4 bit precision, shift 2
+	lpcResidual := make([]int64, 0, blockSize)
+	for i := 2; i < blockSize; i++ {
+		lpcResidual = append(lpcResidual, samples[i]-(7*samples[i-1]-3*samples[i-2])>>2)
+	}
+	tests = append(tests, subframeTest{"LPC", build(func(fb *frameBuilder) {
+		fb.subframeHeader(frame.LPCSubframe+1, 0)
+		fb.signed(samples[0], 16)
+		fb.signed(samples[1], 16)
+		fb.write(3, 4)
+		fb.signed(2, 5)
+		fb.signed(7, 4)
+		fb.signed(-3, 4)
+		fb.residual(frame.RiceCodingMethod, 0, []testPartition{{parameter: 10}}, lpcResidual, 2)
+	}), samples})
+
+	for _, test := range tests {
+		f := readTestFrame(t, test.frame, nil)
+		if len(f.Subframes) != 1 {
+			t.Fatalf("%s: %d subframes", test.name, len(f.Subframes))
+		}
+		checkTestSamples(t, test.name, f.Subframes[0].Samples, test.expected)
+	}
+
+	// LPC subframe fields
+	f := readTestFrame(t, tests[len(tests)-1].frame, nil)
+	subframe := f.Subframes[0]
+	if subframe.QLPPrecision != 4 || subframe.QLPShift != 2 || !reflect.DeepEqual(subframe.QLPCoefficients, []int32{7, -3}) {
+		t.Errorf("LPC subframe precision %d, shift %d, coefficients %v", subframe.QLPPrecision, subframe.QLPShift, subframe.QLPCoefficients)
+	}
+	if !reflect.DeepEqual(subframe.Warmup, []int32{int32(samples[0]), int32(samples[1])}) {
+		t.Errorf("LPC subframe warm-up %v", subframe.Warmup)
+	}
+}
+
+func TestReadResidual(t *testing.T) {
+	const blockSize = 16
+	// FIXED order 2 with partition order 2: the first partition holds 2 residuals, the others 4
+	residual := []int64{
+		0, 0,
+		100000, -70000, 1, -1,
+		0, 0, 0, 0,
+		63, -64, 5, -5,
+	}
+	expected := make([]int64, blockSize)
+	expected[0], expected[1] = 10, -20
+	for i := 2; i < blockSize; i++ {
+		expected[i] = residual[i-2] + 2*expected[i-1] - expected[i-2]
+	}
+
+	tests := []struct {
+		name       string
+		method     frame.ResidualCodingMethod
+		partitions []testPartition
+	}{
+		// Rice parameter 17 is possible only with RICE2, escaped partitions of 0 and 7 bits
+		{"RICE2", frame.Rice2CodingMethod, []testPartition{{parameter: 0}, {parameter: 17}, {parameter: 0, escaped: true}, {parameter: 7, escaped: true}}},
+		{"RICE", frame.RiceCodingMethod, []testPartition{{parameter: 0}, {parameter: 14}, {parameter: 0, escaped: true}, {parameter: 7, escaped: true}}},
+	}
+	for _, test := range tests {
+		fb := &frameBuilder{}
+		fb.header(false, []byte{0}, blockSize, 0, 4)
+		fb.subframeHeader(frame.FixedSubframe+2, 0)
+		fb.signed(expected[0], 16)
+		fb.signed(expected[1], 16)
+		fb.residual(test.method, 2, test.partitions, residual, 2)
+		f := readTestFrame(t, fb.end(), nil)
+		checkTestSamples(t, test.name, f.Subframes[0].Samples, expected)
+
+		decoded := f.Subframes[0].Residual
+		if decoded.CodingMethod != test.method || decoded.PartitionOrder != 2 || len(decoded.Partitions) != 4 {
+			t.Fatalf("%s: residual %+v", test.name, decoded)
+		}
+		for p, partition := range test.partitions {
+			if !partition.escaped && decoded.Partitions[p].Parameter != uint8(partition.parameter) {
+				t.Errorf("%s: partition %d parameter %d", test.name, p, decoded.Partitions[p].Parameter)
+			}
+			if partition.escaped && (!decoded.Partitions[p].IsEscaped(test.method) || decoded.Partitions[p].EscapeBitsPerSample != uint8(partition.parameter)) {
+				t.Errorf("%s: partition %d is not escaped with %d bits", test.name, p, partition.parameter)
+			}
+		}
+	}
+
+	// the predictor order must fit the first partition
+	fb := &frameBuilder{}
+	fb.header(false, []byte{0}, blockSize, 0, 4)
+	fb.subframeHeader(frame.FixedSubframe+2, 0)
+	fb.signed(0, 16)
+	fb.signed(0, 16)
+	fb.write(uint64(frame.RiceCodingMethod), 2)
+	fb.write(4, 4)
+	_, err := frame.ReadFrame(bitio.NewReader(bytes.NewReader(fb.end())), nil)
+	if err == nil {
+		t.Error("partition order 4 with predictor order 2 is read")
+	}
+}
+
+func TestDecorrelation(t *testing.T) {
+	for _, bitsPerSample := range []int{16, 32} {
+		const blockSize = 16
+		streamInfo := &meta.StreamInfo{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: uint8(bitsPerSample)}
+		max, min := int64(1)<<uint(bitsPerSample-1)-1, -int64(1)<<uint(bitsPerSample-1)
+		left := []int64{max, min, max, min, 0, -1, 1, -5, 7, 3, -3, 100, -100, 2, 1, 0}
+		right := []int64{min, max, max, min, -1, 0, -2, 5, 6, 3, 4, -101, 101, 1, 1, 0}
+
+		// independent, left/side, right/side and mid/side stereo
+		for assigment := uint64(1); assigment <= uint64(frame.MidSideStereo); assigment++ {
+			if assigment > 1 && assigment < uint64(frame.LeftSideStereo) {
+				continue
+			}
+			var first, second []int64
+			firstBits, secondBits := bitsPerSample, bitsPerSample
+			for i := range left {
+				side := left[i] - right[i]
+				switch frame.ChannelAssigment(assigment) {
+				case frame.LeftSideStereo:
+					first, second = append(first, left[i]), append(second, side)
+					secondBits = bitsPerSample + 1
+				case frame.RightSideStereo:
+					first, second = append(first, side), append(second, right[i])
+					firstBits = bitsPerSample + 1
+				case frame.MidSideStereo:
+					first, second = append(first, (left[i]+right[i])>>1), append(second, side)
+					secondBits = bitsPerSample + 1
+				default:
+					first, second = append(first, left[i]), append(second, right[i])
+				}
+			}
+
+			fb := &frameBuilder{}
+			fb.header(false, []byte{0}, blockSize, assigment, 0)
+			for _, channel := range []struct {
+				samples []int64
+				bits    int
+			}{{first, firstBits}, {second, secondBits}} {
+				fb.subframeHeader(frame.VerbatimSubframe, 0)
+				for _, sample := range channel.samples {
+					fb.signed(sample, channel.bits)
+				}
+			}
+			f := readTestFrame(t, fb.end(), streamInfo)
+			name := fmt.Sprintf("%d bit channel assigment %d", bitsPerSample, assigment)
+			checkTestSamples(t, name+" left", f.Subframes[0].Samples, left)
+			checkTestSamples(t, name+" right", f.Subframes[1].Samples, right)
+		}
+	}
+
+	// 33 bit side channel of 32 bit stereo with FIXED predictor
+	const blockSize = 16
+	streamInfo := &meta.StreamInfo{SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 32}
+	var left, right, mid, side []int64
+	for i := int64(0); i < blockSize; i++ {
+		left = append(left, 1<<31-1-i)
+		right = append(right, -1<<31+i)
+		mid = append(mid, (left[i]+right[i])>>1)
+		side = append(side, left[i]-right[i])
+	}
+	fb := &frameBuilder{}
+	fb.header(false, []byte{0}, blockSize, uint64(frame.MidSideStereo), 0)
+	fb.subframeHeader(frame.VerbatimSubframe, 0)
+	for _, sample := range mid {
+		fb.signed(sample, 32)
+	}
+	fb.subframeHeader(frame.FixedSubframe+1, 0)
+	fb.signed(side[0], 33)
+	fb.residual(frame.RiceCodingMethod, 0, []testPartition{{parameter: 2}}, fixedTestResidual(side, 1), 1)
+	f := readTestFrame(t, fb.end(), streamInfo)
+	checkTestSamples(t, "32 bit FIXED side left", f.Subframes[0].Samples, left)
+	checkTestSamples(t, "32 bit FIXED side right", f.Subframes[1].Samples, right)
+}
+
+func TestFrameNumbers(t *testing.T) {
+	streamInfo := &meta.StreamInfo{MinimumBlockSize: 4096, MaximumBlockSize: 4096, SampleRate: 44100, NumberOfChannels: 1, BitsPerSample: 16}
+	tests := []struct {
+		variable     bool
+		coded        []byte
+		number       uint64
+		sampleNumber uint64
+	}{
+		{false, []byte{0x00}, 0, 0},
+		{false, []byte{0x7F}, 0x7F, 0x7F * 4096},
+		{false, []byte{0xC2, 0x80}, 0x80, 0x80 * 4096},
+		{false, []byte{0xDF, 0xBF}, 0x7FF, 0x7FF * 4096},
+		{false, []byte{0xE0, 0xA0, 0x80}, 0x800, 0x800 * 4096},
+		{false, []byte{0xFD, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF}, 1<<31 - 1, (1<<31 - 1) * 4096},
+		{true, []byte{0xF0, 0x90, 0x80, 0x80}, 0x10000, 0x10000},
+		{true, []byte{0xFE, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF}, 1<<36 - 1, 1<<36 - 1},
+	}
+	for _, test := range tests {
+		fb := &frameBuilder{}
+		fb.header(test.variable, test.coded, 16, 0, 4)
+		fb.subframeHeader(frame.ConstantSubframe, 0)
+		fb.signed(0, 16)
+		f := readTestFrame(t, fb.end(), streamInfo)
+		if f.Header.VariableBlockSize != test.number {
+			t.Errorf("% X is decoded as %d, expected %d", test.coded, f.Header.VariableBlockSize, test.number)
+		}
+		if f.Header.GetSampleNumber(streamInfo) != test.sampleNumber {
+			t.Errorf("% X: sample number %d, expected %d", test.coded, f.Header.GetSampleNumber(streamInfo), test.sampleNumber)
+		}
+	}
+
+	// broken continuation byte, too long sequence and frame number of 32 bits
+	for _, coded := range [][]byte{{0xC2, 0x00}, {0xFF, 0x80}, {0xFE, 0x82, 0x80, 0x80, 0x80, 0x80, 0x80}} {
+		fb := &frameBuilder{}
+		fb.header(false, coded, 16, 0, 4)
+		fb.subframeHeader(frame.ConstantSubframe, 0)
+		fb.signed(0, 16)
+		_, err := frame.ReadFrame(bitio.NewReader(bytes.NewReader(fb.end())), streamInfo)
+		if err == nil {
+			t.Errorf("incorrect frame number % X is read", coded)
+		}
+	}
+}
+
+// return stream of STREAMINFO and the frames
+func buildTestStream(t *testing.T, streamInfo *meta.StreamInfo, frames ...[]byte) []byte {
+	t.Helper()
+	block := meta.MetadataBlock{
+		Header: meta.MetadataBlockHeader{IsLast: true, Type: meta.StreamInfoBlockType, Length: 34},
+		Data:   streamInfo,
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString("fLaC")
+	_, err := block.WriteTo(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		buffer.Write(f)
+	}
+	return buffer.Bytes()
+}
+
+func TestCRCError(t *testing.T) {
+	streamInfo := &meta.StreamInfo{MinimumBlockSize: 16, MaximumBlockSize: 16, SampleRate: 44100, NumberOfChannels: 1, BitsPerSample: 16}
+	build := func(number byte) []byte {
+		fb := &frameBuilder{}
+		fb.header(false, []byte{number}, 16, 0, 4)
+		fb.subframeHeader(frame.VerbatimSubframe, 0)
+		for i := 0; i < 16; i++ {
+			fb.signed(int64(i*100), 16)
+		}
+		return fb.end()
+	}
+
+	// header CRC-8: the byte after the block size
+	broken := build(5)
+	headerSize := 4 + 1 + 2
+	stored := broken[headerSize]
+	broken[headerSize] ^= 0x55
+	_, err := frame.ReadFrame(bitio.NewReader(bytes.NewReader(broken)), streamInfo)
+	expected := &frame.CRCError{FrameNumber: 5, Offset: -1, Footer: false, Stored: uint16(stored ^ 0x55), Computed: uint16(stored)}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("broken header: %v, expected %v", err, expected)
+	}
+
+	// footer CRC-16: a sample bit
+	broken = build(5)
+	broken[headerSize+3] ^= 0x01
+	_, err = frame.ReadFrame(bitio.NewReader(bytes.NewReader(broken)), streamInfo)
+	storedCRC := uint16(broken[len(broken)-2])<<8 | uint16(broken[len(broken)-1])
+	expected = &frame.CRCError{FrameNumber: 5, Offset: -1, Footer: true, Stored: storedCRC, Computed: testCRC16(broken[:len(broken)-2])}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("broken frame: %v, expected %v", err, expected)
+	}
+
+	// the decoder sets the offset of the frame in the stream
+	first := build(0)
+	stream := buildTestStream(t, streamInfo, first, broken)
+	decoder, err := flac.NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decoder.NextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decoder.NextFrame()
+	expected.Offset = int64(4 + 4 + 34 + len(first))
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("decoder: %v, expected %v", err, expected)
+	}
+}
@@ -0,0 +1,232 @@
+package test
+
+import (
+	"bytes"
+	"frolovo22/flac"
+	"frolovo22/flac/meta"
+	"github.com/icza/bitio"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func testMetadataBlocks() []meta.MetadataBlock {
+	reserved := make([]byte, 259)
+	reserved[0] = 0x55
+	return []meta.MetadataBlock{
+		{Header: meta.MetadataBlockHeader{Type: meta.StreamInfoBlockType}, Data: &meta.StreamInfo{
+			MinimumBlockSize: 4096, MaximumBlockSize: 4096, MinimumFrameSize: 14, MaximumFrameSize: 12000,
+			SampleRate: 44100, NumberOfChannels: 2, BitsPerSample: 16, TotalSamplesInStream: 1 << 33,
+			MD5: []byte("0123456789abcdef")}},
+		{Header: meta.MetadataBlockHeader{Type: meta.PaddingBlockType}, Data: &meta.Padding{Data: make([]byte, 8192)}},
+		{Header: meta.MetadataBlockHeader{Type: meta.ApplicationBlockType}, Data: &meta.Application{ID: "test", Data: []byte{1, 2, 3}}},
+		{Header: meta.MetadataBlockHeader{Type: meta.SeekTableBlockType}, Data: &meta.SeekTable{SeekPoints: []meta.SeekPoint{
+			{SampleNumberOfFirstSample: 0, Offset: 0, NumberOfSamples: 4096},
+			{SampleNumberOfFirstSample: 441000, Offset: 123456, NumberOfSamples: 4096},
+			{SampleNumberOfFirstSample: meta.PlaceholderSampleNumber},
+		}}},
+		{Header: meta.MetadataBlockHeader{Type: meta.VorbisCommentBlockType}, Data: &meta.VorbisComment{
+			VendorLength: 999, VendorString: "reference libFLAC 1.3.2 20170101", UserCommentsLength: 7,
+			UserComments: []meta.UserComment{
+				{Length: 1, Key: "TITLE", Value: "Bee Moved"},
+				{Key: "COMMENT", Value: "a=b"},
+			}}},
+		{Header: meta.MetadataBlockHeader{Type: meta.CueSheetBlockType}, Data: &meta.CueSheet{
+			MediaCatalogNumber: "1234567890123", NumberOfLeadInSamples: 88200, CompactDisc: true, Reserved: reserved,
+			NumberOfTracks: 9,
+			CueSheetTracks: []meta.CueSheetTrack{
+				{OffsetInSamples: 0, TrackNumber: 1, ISRC: "USRC17607839", PreEmphasis: true,
+					CueSheetTrackIndexes: []meta.CueSheetTrackIndex{{OffsetInSamples: 0, IndexPointNumber: 1}}},
+				{OffsetInSamples: 441000, TrackNumber: 170, NonAudioType: true},
+			}}},
+		{Header: meta.MetadataBlockHeader{Type: meta.PictureBlockType, IsLast: true}, Data: &meta.Picture{
+			Type: 3, MIME: "image/png", Description: "cover", Width: 500, Height: 500, BitsPerPixel: 24,
+			PictureData: bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 3000)}},
+	}
+}
+
+func TestMetadataBlockRoundTrip(t *testing.T) {
+	var expected bytes.Buffer
+	for _, block := range testMetadataBlocks() {
+		_, err := block.WriteTo(&expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// short reads of the underlying reader
+	reader := bitio.NewReader(iotest.OneByteReader(bytes.NewReader(expected.Bytes())))
+	var actual bytes.Buffer
+	for _, block := range testMetadataBlocks() {
+		parsed, err := meta.ReadMetadataBlock(reader)
+		if err != nil {
+			t.Fatal(block.Header.Type.String(), err)
+		}
+		if parsed.Header.Type != block.Header.Type || parsed.Header.IsLast != block.Header.IsLast {
+			t.Errorf("header %+v, expected %+v", parsed.Header, block.Header)
+		}
+		n, err := parsed.WriteTo(&actual)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(4+parsed.Header.Length) {
+			t.Errorf("%s block of %d bytes, header length %d", parsed.Header.Type.String(), n, parsed.Header.Length)
+		}
+	}
+	_, err := meta.ReadMetadataBlock(reader)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF after the last block, got %v", err)
+	}
+	if !bytes.Equal(actual.Bytes(), expected.Bytes()) {
+		t.Error("serialized metadata differs from the parsed bytes")
+	}
+
+	// lengths are computed, not copied from stale fields
+	reader = bitio.NewReader(bytes.NewReader(expected.Bytes()))
+	var comment *meta.VorbisComment
+	for comment == nil {
+		block, err := meta.ReadMetadataBlock(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		comment, _ = block.Data.(*meta.VorbisComment)
+	}
+	expectedComment := testMetadataBlocks()[4].Data.(*meta.VorbisComment)
+	if comment.VendorString != expectedComment.VendorString || int(comment.VendorLength) != len(comment.VendorString) ||
+		!reflect.DeepEqual(comment.UserComments[1], meta.UserComment{Length: 11, Key: "COMMENT", Value: "a=b"}) {
+		t.Errorf("VORBIS_COMMENT %+v", comment)
+	}
+}
+
+func TestMetadataBlockManagement(t *testing.T) {
+	data, _ := encodeTestStream(t)
+	f, err := flac.Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := testMetadataBlocks()
+	streamInfo, padding, application, seekTable, comment, picture := blocks[0], blocks[1], blocks[2], blocks[3], blocks[4], blocks[6]
+
+	for _, block := range []meta.MetadataBlock{padding, comment, application, picture} {
+		err = f.AddMetadataBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = f.InsertMetadataBlock(1, seekTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.MoveMetadataBlock(2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.ReplaceMetadataBlock(meta.MetadataBlock{
+		Header: meta.MetadataBlockHeader{Type: meta.VorbisCommentBlockType},
+		Data:   &meta.VorbisComment{VendorString: "replaced"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := f.RemoveMetadataBlocks(meta.ApplicationBlockType)
+	if err != nil || removed != 1 {
+		t.Fatalf("removed %d blocks, %v", removed, err)
+	}
+
+	// STREAMINFO, SEEKTABLE, VORBIS_COMMENT, PICTURE, PADDING
+	expected := []meta.BlockType{meta.StreamInfoBlockType, meta.SeekTableBlockType, meta.VorbisCommentBlockType,
+		meta.PictureBlockType, meta.PaddingBlockType}
+	if len(f.MetadataBlocks) != len(expected) {
+		t.Fatalf("%d metadata blocks", len(f.MetadataBlocks))
+	}
+	for i, block := range f.MetadataBlocks {
+		if block.Header.Type != expected[i] {
+			t.Errorf("block %d is %s, expected %s", i, block.Header.Type.String(), expected[i].String())
+		}
+		if block.Header.IsLast != (i == len(expected)-1) {
+			t.Errorf("block %d IsLast is %v", i, block.Header.IsLast)
+		}
+	}
+	if f.MetadataBlocks[2].Header.Length != 4+len("replaced")+4 || f.MetadataBlocks[4].Header.Length != 8192 {
+		t.Error("metadata block lengths are not recomputed")
+	}
+
+	// the rules are kept, the blocks are not changed on error
+	before := append([]meta.MetadataBlock{}, f.MetadataBlocks...)
+	for name, change := range map[string]func() error{
+		"second STREAMINFO":       func() error { return f.AddMetadataBlock(streamInfo) },
+		"second SEEKTABLE":        func() error { return f.AddMetadataBlock(seekTable) },
+		"second VORBIS_COMMENT":   func() error { return f.InsertMetadataBlock(3, comment) },
+		"block before STREAMINFO": func() error { return f.InsertMetadataBlock(0, padding) },
+		"STREAMINFO moved":        func() error { return f.MoveMetadataBlock(0, 2) },
+		"STREAMINFO removed": func() error {
+			_, err := f.RemoveMetadataBlocks(meta.StreamInfoBlockType)
+			return err
+		},
+	} {
+		if change() == nil {
+			t.Errorf("%s is accepted", name)
+		}
+		if !reflect.DeepEqual(before, f.MetadataBlocks) {
+			t.Fatalf("%s changed the blocks", name)
+		}
+	}
+
+	// move by type: PICTURE to the end, then PADDING before VORBIS_COMMENT
+	err = f.MoveMetadataBlocks(meta.PictureBlockType, len(f.MetadataBlocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.MoveMetadataBlocks(meta.PaddingBlockType, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []meta.BlockType{meta.StreamInfoBlockType, meta.SeekTableBlockType, meta.PaddingBlockType,
+		meta.VorbisCommentBlockType, meta.PictureBlockType}
+	for i, block := range f.MetadataBlocks {
+		if block.Header.Type != expected[i] {
+			t.Errorf("block %d is %s, expected %s", i, block.Header.Type.String(), expected[i].String())
+		}
+		if block.Header.IsLast != (i == len(expected)-1) {
+			t.Errorf("block %d IsLast is %v", i, block.Header.IsLast)
+		}
+	}
+	if f.MoveMetadataBlocks(meta.StreamInfoBlockType, 2) == nil {
+		t.Error("STREAMINFO is moved")
+	}
+	if f.MoveMetadataBlocks(meta.ApplicationBlockType, 0) == nil {
+		t.Error("missing APPLICATION is moved")
+	}
+}
+
+func TestDuplicateMetadataBlocks(t *testing.T) {
+	data, _ := encodeTestStream(t)
+	f, err := flac.Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := testMetadataBlocks()[4]
+	err = f.AddMetadataBlock(comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.AddMetadataBlock(comment)
+	if err == nil || err.Error() != "more than one VORBIS_COMMENT metadata block" {
+		t.Errorf("second VORBIS_COMMENT is added: %v", err)
+	}
+	if len(f.MetadataBlocks) != 2 {
+		t.Fatalf("%d metadata blocks", len(f.MetadataBlocks))
+	}
+
+	// the blocks edited by hand are checked by the next change
+	f.MetadataBlocks = append(f.MetadataBlocks, comment)
+	err = f.AddMetadataBlock(testMetadataBlocks()[1])
+	if err == nil {
+		t.Error("block is added to the blocks with two VORBIS_COMMENT")
+	}
+	removed, err := f.RemoveMetadataBlocks(meta.VorbisCommentBlockType)
+	if err != nil || removed != 2 {
+		t.Fatalf("removed %d blocks, %v", removed, err)
+	}
+}
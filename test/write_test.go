@@ -0,0 +1,331 @@
+package test
+
+import (
+	"bytes"
+	"frolovo22/flac"
+	"frolovo22/flac/meta"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+// encode test stream with STREAMINFO only, returns the stream and its audio frames
+func encodeTestStream(t *testing.T) ([]byte, []byte) {
+	options := flac.Level(5)
+	options.SampleRate, options.NumberOfChannels, options.BitsPerSample = 44100, 2, 16
+	data := encode(t, options, generateSamples(2, 20000, 16))
+	return data, data[4+4+34:]
+}
+
+func addComment(f *flac.FLAC) {
+	f.MetadataBlocks = append(f.MetadataBlocks, meta.MetadataBlock{
+		Header: meta.MetadataBlockHeader{Type: meta.VorbisCommentBlockType},
+		Data: &meta.VorbisComment{VendorString: "test", UserComments: []meta.UserComment{
+			{Key: "TITLE", Value: "Bee Moved"},
+		}},
+	})
+}
+
+func TestWrite(t *testing.T) {
+	data, audio := encodeTestStream(t)
+
+	f, err := flac.Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	err = f.Write(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer.Bytes(), data) {
+		t.Error("unchanged stream is written differently")
+	}
+
+	addComment(f)
+	buffer.Reset()
+	err = f.Write(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := buffer.Bytes()
+	if !bytes.HasSuffix(written, audio) {
+		t.Error("audio frames are changed")
+	}
+	// STREAMINFO is not the last block anymore
+	if written[4]&0x80 != 0 {
+		t.Error("STREAMINFO is still the last metadata block")
+	}
+	edited, err := flac.Read(bytes.NewReader(written))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edited.MetadataBlocks) != 2 {
+		t.Errorf("%d metadata blocks are read back", len(edited.MetadataBlocks))
+	}
+
+	// the audio frames of other readers are not kept
+	f, err = flac.Read(iotest.OneByteReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Write(ioutil.Discard)
+	if err != flac.ErrAudioNotAvailable {
+		t.Errorf("write of not kept audio frames: %v", err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	data, audio := encodeTestStream(t)
+	dir, err := ioutil.TempDir("", "flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.flac")
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite the file in place twice, the second time from the rewritten file
+	f, err := flac.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addComment(f)
+	err = f.WriteFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addComment(f)
+	err = f.WriteFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(written, audio) || len(written) <= len(data) {
+		t.Error("audio frames are changed")
+	}
+	// the test stream has no MD5 signature
+	err = flac.VerifyFile(path)
+	if err != flac.ErrMD5NotSet {
+		t.Error(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("file mode %v is not kept", info.Mode())
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("%d files in the directory, temporary file is left", len(files))
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	data, audio := encodeTestStream(t)
+	dir, err := ioutil.TempDir("", "flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.flac")
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(size int, padding int) {
+		written, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasSuffix(written, audio) {
+			t.Fatal("audio frames are changed")
+		}
+		if size > 0 && len(written) != size {
+			t.Errorf("file size %d, expected %d", len(written), size)
+		}
+		f, err := flac.Read(bytes.NewReader(written))
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := f.MetadataBlocks[len(f.MetadataBlocks)-1]
+		if last.Header.Type != meta.PaddingBlockType || last.Header.Length != padding {
+			t.Errorf("last metadata block %+v, expected PADDING of %d bytes", last.Header, padding)
+		}
+	}
+
+	// no padding, the file is rewritten with the default padding
+	err = flac.UpdateMetadata(path, func(f *flac.FLAC) error {
+		addComment(f)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(0, flac.DefaultPadding)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int(info.Size())
+
+	// the comment grows in place
+	err = flac.UpdateMetadata(path, func(f *flac.FLAC) error {
+		comment := f.MetadataBlocks[1].Data.(*meta.VorbisComment)
+		comment.UserComments = append(comment.UserComments, meta.UserComment{Key: "ARTIST", Value: "Kevin MacLeod"})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(size, flac.DefaultPadding-len("ARTIST=Kevin MacLeod")-4)
+
+	// the comment does not fit the padding
+	err = flac.UpdateMetadataWithPadding(path, 100, func(f *flac.FLAC) error {
+		comment := f.MetadataBlocks[1].Data.(*meta.VorbisComment)
+		comment.VendorString = string(make([]byte, 2*flac.DefaultPadding))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(0, 100)
+}
+
+func TestReadMetadata(t *testing.T) {
+	data, audio := encodeTestStream(t)
+	f, err := flac.Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	picture := testMetadataBlocks()[6]
+	addComment(f)
+	err = f.AddMetadataBlock(picture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.AddMetadataBlock(meta.MetadataBlock{
+		Header: meta.MetadataBlockHeader{Type: meta.PaddingBlockType},
+		Data:   &meta.Padding{Data: make([]byte, 100)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	err = f.Write(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// broken first frame does not matter
+	stream := buffer.Bytes()
+	audioOffset := len(stream) - len(audio)
+	stream[audioOffset+10] ^= 0xFF
+
+	_, err = flac.Read(bytes.NewReader(stream))
+	if err == nil {
+		t.Error("broken first frame is read")
+	}
+
+	for _, skip := range []bool{false, true} {
+		for _, reader := range []io.Reader{bytes.NewReader(stream), iotest.OneByteReader(bytes.NewReader(stream))} {
+			metadata, err := flac.ReadMetadataWithOptions(reader, flac.MetadataOptions{SkipPictureData: skip})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata.AudioOffset() != int64(audioOffset) {
+				t.Errorf("audio offset %d, expected %d", metadata.AudioOffset(), audioOffset)
+			}
+			if len(metadata.MetadataBlocks) != 4 || metadata.MetadataBlocks[3].Header.Type != meta.PaddingBlockType {
+				t.Fatalf("%d metadata blocks", len(metadata.MetadataBlocks))
+			}
+			expected := *picture.Data.(*meta.Picture)
+			if skip {
+				expected.PictureData, expected.DataSkipped = nil, true
+			}
+			if !reflect.DeepEqual(metadata.MetadataBlocks[2].Data, &expected) {
+				t.Error("PICTURE is read incorrectly")
+			}
+			err = metadata.Write(ioutil.Discard)
+			if skip && err == nil {
+				t.Error("metadata without PICTURE data is written")
+			}
+		}
+	}
+
+	// the metadata is written after PICTURE without data is removed
+	metadata, err := flac.ReadMetadataWithOptions(bytes.NewReader(stream), flac.MetadataOptions{SkipPictureData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pictureLength := metadata.MetadataBlocks[2].Header.Length
+	err = metadata.AddMetadataBlock(meta.MetadataBlock{
+		Header: meta.MetadataBlockHeader{Type: meta.ApplicationBlockType},
+		Data:   &meta.Application{ID: "test", Data: []byte{1, 2, 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.MetadataBlocks[2].Header.Length != pictureLength {
+		t.Errorf("length %d of PICTURE without data is recomputed", metadata.MetadataBlocks[2].Header.Length)
+	}
+	_, err = metadata.RemoveMetadataBlocks(meta.PictureBlockType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rewritten bytes.Buffer
+	err = metadata.Write(&rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(rewritten.Bytes(), stream[audioOffset:]) {
+		t.Error("audio frames are changed")
+	}
+
+	// the file keeps its audio frames on rewrite
+	dir, err := ioutil.TempDir("", "flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.flac")
+	err = ioutil.WriteFile(path, stream, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err = flac.ReadMetadataFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = metadata.RemoveMetadataBlocks(meta.PictureBlockType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = metadata.WriteFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written[metadata.AudioOffset():], stream[audioOffset:]) {
+		t.Error("audio frames are changed")
+	}
+}
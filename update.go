@@ -0,0 +1,103 @@
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"frolovo22/flac/meta"
+	"os"
+)
+
+// size of PADDING added when UpdateMetadata rewrites the file, the same as the reference encoder adds
+const DefaultPadding = 8192
+
+// edit metadata of the file like metaflac, see UpdateMetadataWithPadding
+func UpdateMetadata(path string, update func(*FLAC) error) error {
+	return UpdateMetadataWithPadding(path, DefaultPadding, update)
+}
+
+// read metadata blocks of the file, change them by update and write them back
+// if the new metadata fits in the old metadata area, PADDING is shrunk or grown to fill the area
+// and only the metadata is overwritten in place, otherwise the file is rewritten with padding bytes of PADDING,
+// see FLAC.WriteFile; the audio frames are never decoded or changed
+// the first PADDING block keeps its position, other PADDING blocks are removed
+func UpdateMetadataWithPadding(path string, padding int, update func(*FLAC) error) error {
+	f, err := ReadMetadataFile(path)
+	if err != nil {
+		return err
+	}
+	err = update(f)
+	if err != nil {
+		return err
+	}
+
+	// metadata without padding
+	blocks := f.MetadataBlocks
+	paddingIndex := len(blocks)
+	f.MetadataBlocks = nil
+	for _, block := range blocks {
+		if block.Header.Type == meta.PaddingBlockType {
+			if paddingIndex == len(blocks) {
+				paddingIndex = len(f.MetadataBlocks)
+			}
+			continue
+		}
+		f.MetadataBlocks = append(f.MetadataBlocks, block)
+	}
+	var buffer bytes.Buffer
+	err = f.writeMetadata(&buffer)
+	if err != nil {
+		return err
+	}
+
+	// in place: the same size or the rest of the area is filled by PADDING with its header
+	size := int64(buffer.Len())
+	area := f.audio.offset
+	if size == area || (size+4 <= area && area-size-4 < 1<<24) {
+		if size < area {
+			err = f.insertPadding(paddingIndex, int(area-size-4))
+			if err != nil {
+				return err
+			}
+		}
+		buffer.Reset()
+		err = f.writeMetadata(&buffer)
+		if err != nil {
+			return err
+		}
+		// never overwrite the audio frames
+		if int64(buffer.Len()) != area {
+			return errors.New("metadata size does not match the metadata area")
+		}
+		return overwrite(path, buffer.Bytes())
+	}
+
+	if padding > 0 {
+		err = f.insertPadding(paddingIndex, padding)
+		if err != nil {
+			return err
+		}
+	}
+	return f.WriteFile(path)
+}
+
+// insert PADDING of size bytes before metadata block i
+func (f *FLAC) insertPadding(i int, size int) error {
+	return f.InsertMetadataBlock(i, meta.MetadataBlock{
+		Header: meta.MetadataBlockHeader{Type: meta.PaddingBlockType},
+		Data:   &meta.Padding{Data: make([]byte, size)},
+	})
+}
+
+// overwrite the beginning of the file with data
+func overwrite(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, 0)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
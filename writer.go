@@ -0,0 +1,15 @@
+package flac
+
+import "io"
+
+// countWriter counts bytes written to the stream
+type countWriter struct {
+	writer io.Writer
+	offset int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	cw.offset += int64(n)
+	return n, err
+}